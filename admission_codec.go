@@ -0,0 +1,181 @@
+package webhook_core
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// AdmissionSerializer a serializer that is used to decode admission reviews and encode their responses. Register
+// new implementations with `RegisterAdmissionSerializer` to support more encodings.
+type AdmissionSerializer interface {
+	// MediaType media type that this serializer handle, e.g. application/json
+	MediaType() string
+	// IsText is output of this serializer a text, this is used to decide how to log the content
+	IsText() bool
+	// Decode decode `data` into `into` and return kind of the data. If `into` is `*runtime.Unknown` only kind of the
+	// data should be detected.
+	Decode(data []byte, into runtime.Object) (*schema.GroupVersionKind, error)
+	// Encode encode `obj` into `w`
+	Encode(obj runtime.Object, w io.Writer) error
+}
+
+var admissionSerializersLock sync.RWMutex
+var admissionSerializers = make(map[string]AdmissionSerializer)
+
+// RegisterAdmissionSerializer register a serializer for its media type, it replace existing serializer of that
+// media type
+func RegisterAdmissionSerializer(serializer AdmissionSerializer) {
+	admissionSerializersLock.Lock()
+	defer admissionSerializersLock.Unlock()
+
+	admissionSerializers[serializer.MediaType()] = serializer
+}
+
+// GetAdmissionSerializer get serializer of a media type, it return nil if no serializer registered for it
+func GetAdmissionSerializer(mediaType string) AdmissionSerializer {
+	admissionSerializersLock.RLock()
+	defer admissionSerializersLock.RUnlock()
+
+	return admissionSerializers[mediaType]
+}
+
+// GetAdmissionMediaTypes get list of media types that we have a serializer for them
+func GetAdmissionMediaTypes() []string {
+	admissionSerializersLock.RLock()
+	defer admissionSerializersLock.RUnlock()
+
+	result := make([]string, 0, len(admissionSerializers))
+	for mediaType := range admissionSerializers {
+		result = append(result, mediaType)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// codecFactorySerializer an `AdmissionSerializer` that use a serializer of a `serializer.CodecFactory`
+type codecFactorySerializer struct {
+	info runtime.SerializerInfo
+}
+
+// NewCodecFactorySerializer create an `AdmissionSerializer` from a serializer of a `serializer.CodecFactory`
+func NewCodecFactorySerializer(info runtime.SerializerInfo) AdmissionSerializer {
+	return codecFactorySerializer{info: info}
+}
+
+func (this codecFactorySerializer) MediaType() string { return this.info.MediaType }
+func (this codecFactorySerializer) IsText() bool      { return this.info.EncodesAsText }
+func (this codecFactorySerializer) Decode(data []byte, into runtime.Object) (*schema.GroupVersionKind, error) {
+	_, gvk, err := this.info.Serializer.Decode(data, nil, into)
+	return gvk, err
+}
+func (this codecFactorySerializer) Encode(obj runtime.Object, w io.Writer) error {
+	return this.info.Serializer.Encode(obj, w)
+}
+
+// getRequestSerializer find serializer of body of the request from its content type
+func getRequestSerializer(request *http.Request) (AdmissionSerializer, error) {
+	contentType := request.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, "Missing content-type, Expected one of: %v",
+			strings.Join(GetAdmissionMediaTypes(), ", "))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, "Invalid content-type(%v): %v", contentType, err)
+	}
+	serializer := GetAdmissionSerializer(mediaType)
+	if serializer == nil {
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType,
+			"Invalid content-type. Received: %v, Expected one of: %v",
+			mediaType, strings.Join(GetAdmissionMediaTypes(), ", "))
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType,
+			"Invalid charset. Received: %v, Expected: utf-8", charset)
+	}
+	return serializer, nil
+}
+
+// acceptedMediaType a media type of the Accept header with its quality
+type acceptedMediaType struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept parse an Accept header, media types with zero quality are dropped and the rest are sorted by their
+// quality, media types with same quality keep their order
+func parseAccept(accept string) []acceptedMediaType {
+	var result []acceptedMediaType
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		result = append(result, acceptedMediaType{mediaType: mediaType, quality: quality})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].quality > result[j].quality })
+	return result
+}
+
+// matchMediaType check if `mediaType` match `pattern`, pattern may be a wildcard like */* or application/*
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+}
+
+// NegotiateResponseSerializer select serializer of the response using Accept header of the request, media types
+// with higher quality are preferred and those with `q=0` are never used. If none of accepted media types are
+// supported, serializer of the request body or JSON will be used.
+func NegotiateResponseSerializer(request *http.Request) AdmissionSerializer {
+	fallback, err := getRequestSerializer(request)
+	if err != nil {
+		fallback = GetAdmissionSerializer(jsonMIME)
+	}
+
+	accepted := parseAccept(request.Header.Get("Accept"))
+	for _, item := range accepted {
+		if strings.Contains(item.mediaType, "*") {
+			// prefer the encoding of the request for wildcards
+			if matchMediaType(item.mediaType, fallback.MediaType()) {
+				return fallback
+			}
+			for _, mediaType := range GetAdmissionMediaTypes() {
+				if matchMediaType(item.mediaType, mediaType) {
+					return GetAdmissionSerializer(mediaType)
+				}
+			}
+		} else if serializer := GetAdmissionSerializer(item.mediaType); serializer != nil {
+			return serializer
+		}
+	}
+	return fallback
+}
+
+func init() {
+	for _, info := range codecs.SupportedMediaTypes() {
+		if info.MediaType == runtime.ContentTypeJSON || info.MediaType == runtime.ContentTypeYAML {
+			RegisterAdmissionSerializer(NewCodecFactorySerializer(info))
+		}
+	}
+}
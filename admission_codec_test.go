@@ -0,0 +1,49 @@
+package webhook_core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestGetAdmissionMediaTypesIsSorted(t *testing.T) {
+	mediaTypes := GetAdmissionMediaTypes()
+	if !sort.StringsAreSorted(mediaTypes) {
+		t.Errorf("Media types must be sorted, got %v", mediaTypes)
+	}
+}
+
+func TestNegotiateResponseSerializer(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		accept      string
+		expected    string
+	}{
+		{name: "no accept", contentType: "application/yaml", expected: "application/yaml"},
+		{name: "exact", contentType: "application/json", accept: "application/yaml", expected: "application/yaml"},
+		{name: "unsupported", contentType: "application/yaml", accept: "text/html", expected: "application/yaml"},
+		{name: "zero quality is skipped", contentType: "application/json",
+			accept: "application/yaml;q=0, application/json", expected: "application/json"},
+		{name: "higher quality is preferred", contentType: "application/json",
+			accept: "application/json;q=0.5, application/yaml;q=0.9", expected: "application/yaml"},
+		{name: "same quality keep order", contentType: "application/json",
+			accept: "application/yaml, application/json", expected: "application/yaml"},
+		{name: "wildcard prefer request encoding", contentType: "application/yaml", accept: "*/*",
+			expected: "application/yaml"},
+		{name: "invalid content type", contentType: "text/plain", accept: "", expected: "application/json"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/validate/test", nil)
+			request.Header.Set("Content-Type", test.contentType)
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+			if mediaType := NegotiateResponseSerializer(request).MediaType(); mediaType != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, mediaType)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package webhook_core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionApi "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FailureAction action that should be taken when a webhook can't reach a decision
+type FailureAction string
+
+const (
+	// FailureActionDeny reply with a response that deny the request
+	FailureActionDeny FailureAction = "deny"
+	// FailureActionAllow reply with a response that allow the request
+	FailureActionAllow FailureAction = "allow"
+	// FailureActionFail reply with HTTP 500, so the API server apply failurePolicy of the webhook
+	FailureActionFail FailureAction = "fail"
+)
+
+var (
+	InvalidFailureAction = errors.New("Invalid failure action, valid values are deny, allow and fail")
+)
+
+func (this FailureAction) String() string { return string(this) }
+
+// Set implement `flag.Value`
+func (this *FailureAction) Set(value string) error {
+	switch action := FailureAction(strings.ToLower(value)); action {
+	case FailureActionDeny, FailureActionAllow, FailureActionFail:
+		*this = action
+		return nil
+	default:
+		return InvalidFailureAction
+	}
+}
+
+// getStatusReasonCode get HTTP status code that matches `reason` of a failure
+func getStatusReasonCode(reason metav1.StatusReason) int32 {
+	switch reason {
+	case metav1.StatusReasonTimeout:
+		return http.StatusGatewayTimeout
+	case metav1.StatusReasonServiceUnavailable:
+		return http.StatusServiceUnavailable
+	case metav1.StatusReasonTooManyRequests:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CreateFailureResponse create a response for a request that webhook failed to handle it, it return nil if action
+// is `FailureActionFail`, in that case caller must reply with an HTTP error. Code of the denial is derived from
+// `reason`.
+func (this FailureAction) CreateFailureResponse(webhook AdmissionWebhook, reason metav1.StatusReason, err error) *admissionApi.AdmissionResponse {
+	switch this {
+	case FailureActionAllow:
+		// API server prefix keys of audit annotations with name of the webhook and reject keys that contain `/`
+		return AllowResponse().
+			WithAuditAnnotation("failure", err.Error()).
+			Response()
+	case FailureActionDeny:
+		return DenyResponsef("Webhook %s failed: %v", webhook.Name(), err).
+			WithCode(getStatusReasonCode(reason)).
+			WithReason(reason).
+			Response()
+	default:
+		return nil
+	}
+}
+
+// PolicyError an error that webhooks return to deny a request because it violates their policy, unlike other errors
+// it will be sent to the API server as a well formed denial
+type PolicyError struct {
+	response *admissionApi.AdmissionResponse
+}
+
+// NewPolicyError create a `PolicyError` from a denial response
+func NewPolicyError(denial *ResponseBuilder) *PolicyError {
+	response := denial.Response()
+	response.Allowed = false
+	return &PolicyError{response: response}
+}
+
+// PolicyErrorf create a `PolicyError` with a formatted message
+func PolicyErrorf(format string, args ...interface{}) *PolicyError {
+	return NewPolicyError(DenyResponsef(format, args...))
+}
+
+func (this *PolicyError) Error() string {
+	if this.response.Result == nil || this.response.Result.Message == "" {
+		return "Request denied by policy"
+	}
+	return this.response.Result.Message
+}
+
+// Response get the denial response of this error
+func (this *PolicyError) Response() *admissionApi.AdmissionResponse {
+	return this.response
+}
+
+// IsPolicyError check if an error is a `PolicyError`
+func IsPolicyError(err error) bool {
+	var policyError *PolicyError
+	return errors.As(err, &policyError)
+}
+
+// PanicError an error that represent a recovered panic of a webhook
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (this *PanicError) Error() string {
+	return fmt.Sprintf("Webhook panicked: %v", this.Value)
+}
+
+// HTTPError an error that must be reported to the client with a specific HTTP status code
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+// NewHTTPError create a new `HTTPError` with a formatted message
+func NewHTTPError(statusCode int, format string, args ...interface{}) *HTTPError {
+	return &HTTPError{
+		StatusCode: statusCode,
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (this *HTTPError) Error() string { return this.Message }
+
+// GetHTTPStatusCode get HTTP status code that `err` must be reported with, or `defaultCode` if `err` is not an
+// `HTTPError`
+func GetHTTPStatusCode(err error, defaultCode int) int {
+	var httpError *HTTPError
+	if errors.As(err, &httpError) {
+		return httpError.StatusCode
+	}
+	return defaultCode
+}
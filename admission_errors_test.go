@@ -0,0 +1,62 @@
+package webhook_core
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFailureActionCreateFailureResponse(t *testing.T) {
+	webhook := newTestWebhook("test-webhook", ValidatingAdmissionWebhook, nil)
+	failure := errors.New("backend is down")
+
+	tests := []struct {
+		name    string
+		action  FailureAction
+		reason  metav1.StatusReason
+		allowed bool
+		code    int32
+	}{
+		{name: "fail", action: FailureActionFail, reason: metav1.StatusReasonInternalError},
+		{name: "allow", action: FailureActionAllow, reason: metav1.StatusReasonInternalError, allowed: true},
+		{name: "deny internal error", action: FailureActionDeny, reason: metav1.StatusReasonInternalError,
+			code: http.StatusInternalServerError},
+		{name: "deny timeout", action: FailureActionDeny, reason: metav1.StatusReasonTimeout,
+			code: http.StatusGatewayTimeout},
+		{name: "deny overload", action: FailureActionDeny, reason: metav1.StatusReasonTooManyRequests,
+			code: http.StatusTooManyRequests},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := test.action.CreateFailureResponse(webhook, test.reason, failure)
+			if test.action == FailureActionFail {
+				if response != nil {
+					t.Fatalf("Expected no response for %s action", test.action)
+				}
+				return
+			}
+			if response == nil || response.Allowed != test.allowed {
+				t.Fatalf("Expected allowed=%v, got %+v", test.allowed, response)
+			}
+			if test.allowed {
+				for key := range response.AuditAnnotations {
+					// API server prefix the key with name of the webhook and reject keys with more than one `/`
+					if strings.Contains(key, "/") {
+						t.Errorf("Audit annotation key %s must not contain `/`", key)
+					}
+				}
+				if response.AuditAnnotations["failure"] != failure.Error() {
+					t.Errorf("Missing failure audit annotation: %v", response.AuditAnnotations)
+				}
+				return
+			}
+			if response.Result.Code != test.code || response.Result.Reason != test.reason {
+				t.Errorf("Expected code %d and reason %s, got %d and %s",
+					test.code, test.reason, response.Result.Code, response.Result.Reason)
+			}
+		})
+	}
+}
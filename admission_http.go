@@ -1,15 +1,13 @@
 package webhook_core
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"bytes"
+	"io"
 	"net/http"
 	"strings"
 
 	"github.com/devops-simba/helpers"
 
-	log "github.com/golang/glog"
 	admissionApi "k8s.io/api/admission/v1"
 	admissionApiBeta1 "k8s.io/api/admission/v1beta1"
 	admissionRegistration "k8s.io/api/admissionregistration/v1"
@@ -20,6 +18,10 @@ import (
 )
 
 const jsonMIME = "application/json"
+
+// DefaultMaxBodySize default maximum accepted size of an admission review, API server limit objects to 3MiB and
+// a review may contain both the object and the old object
+const DefaultMaxBodySize = 7 * 1024 * 1024
 const verAdmissionApi = "admission.k8s.io/v1"
 const verAdmissionApiBeta1 = "admission.k8s.io/v1beta1"
 
@@ -78,6 +80,7 @@ func v1tobeta1AdmissionResponse(source *admissionApi.AdmissionResponse) *admissi
 		Patch:            source.Patch,
 		PatchType:        v1tobeta1PathType(source.PatchType),
 		AuditAnnotations: source.AuditAnnotations,
+		Warnings:         source.Warnings,
 	}
 }
 func v1tobeta1AdmissionReview(source *admissionApi.AdmissionReview) *admissionApiBeta1.AdmissionReview {
@@ -85,6 +88,7 @@ func v1tobeta1AdmissionReview(source *admissionApi.AdmissionReview) *admissionAp
 		return nil
 	}
 	return &admissionApiBeta1.AdmissionReview{
+		TypeMeta: source.TypeMeta,
 		Request:  v1tobeta1AdmissionRequest(source.Request),
 		Response: v1tobeta1AdmissionResponse(source.Response),
 	}
@@ -134,6 +138,7 @@ func beta1tov1AdmissionResponse(source *admissionApiBeta1.AdmissionResponse) *ad
 		Patch:            source.Patch,
 		PatchType:        beta1tov1PathType(source.PatchType),
 		AuditAnnotations: source.AuditAnnotations,
+		Warnings:         source.Warnings,
 	}
 }
 func beta1tov1AdmissionReview(source *admissionApiBeta1.AdmissionReview) *admissionApi.AdmissionReview {
@@ -141,80 +146,151 @@ func beta1tov1AdmissionReview(source *admissionApiBeta1.AdmissionReview) *admiss
 		return nil
 	}
 	return &admissionApi.AdmissionReview{
+		TypeMeta: source.TypeMeta,
 		Request:  beta1tov1AdmissionRequest(source.Request),
 		Response: beta1tov1AdmissionResponse(source.Response),
 	}
 }
 
-// ReadAdmissionReview read an AdmissionReview from a request
-func ReadAdmissionReview(request *http.Request) (string, *admissionApi.AdmissionReview, error) {
-	var body []byte
-	if request.Body != nil {
-		data, err := ioutil.ReadAll(request.Body)
-		if err != nil {
-			return "", nil, err
-		}
-		body = data
-		if log.V(10) {
-			contentType := request.Header["Content-Type"][0]
-			if strings.HasPrefix(contentType, "text/") || contentType == "application/json" {
-				log.Infof("Body: %s", string(data))
-			} else {
-				log.Infof("Body: %x", data)
-			}
-		}
+// AdmissionReadOptions options that control how an AdmissionReview is read from a request
+type AdmissionReadOptions struct {
+	// MaxBodySize maximum accepted size of the body, zero means `DefaultMaxBodySize`
+	MaxBodySize int64
+	// SupportedVersions admission versions(e.g. v1 or v1beta1) that are accepted, empty means all known versions
+	SupportedVersions []string
+	// Webhook webhook that receive the review, its name is added to the log lines
+	Webhook AdmissionWebhook
+}
+
+func (this AdmissionReadOptions) maxBodySize() int64 {
+	if this.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
 	}
+	return this.MaxBodySize
+}
 
-	if len(body) == 0 {
-		return "", nil, fmt.Errorf("Empty body")
+// readBody read body of the request, it fails if body is larger than `maxBodySize`
+func readBody(request *http.Request, maxBodySize int64) ([]byte, error) {
+	if request.Body == nil {
+		return nil, nil
+	}
+	if request.ContentLength > maxBodySize {
+		return nil, NewHTTPError(http.StatusRequestEntityTooLarge,
+			"Request body is too large(%d bytes), maximum accepted size is %d bytes", request.ContentLength, maxBodySize)
 	}
 
-	contentType := request.Header.Get("Content-Type")
-	if contentType != jsonMIME {
-		return "", nil, fmt.Errorf("Invalid content-type. Received: %v, Expected: %v", jsonMIME, contentType)
+	var buffer bytes.Buffer
+	if request.ContentLength > 0 {
+		buffer.Grow(int(request.ContentLength))
+	}
+	_, err := buffer.ReadFrom(io.LimitReader(request.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(buffer.Len()) > maxBodySize {
+		return nil, NewHTTPError(http.StatusRequestEntityTooLarge,
+			"Request body is too large, maximum accepted size is %d bytes", maxBodySize)
 	}
+	return buffer.Bytes(), nil
+}
 
-	var apiVersion string
-	var ar *admissionApi.AdmissionReview
+// ReadAdmissionReview read an AdmissionReview from a request using default options
+func ReadAdmissionReview(request *http.Request) (string, *admissionApi.AdmissionReview, error) {
+	return ReadAdmissionReviewWithOptions(request, AdmissionReadOptions{})
+}
+
+// ReadAdmissionReviewWithOptions read an AdmissionReview from a request. Returned errors that must be reported with
+// a specific HTTP status code are `*HTTPError`
+func ReadAdmissionReviewWithOptions(request *http.Request, options AdmissionReadOptions) (string, *admissionApi.AdmissionReview, error) {
+	serializer, err := getRequestSerializer(request)
+	if err != nil {
+		return "", nil, err
+	}
 
-	// try to work with v1
-	arV1 := admissionApi.AdmissionReview{}
-	_, _, err := deserializer.Decode(body, nil, &arV1)
+	body, err := readBody(request, options.maxBodySize())
+	if err != nil {
+		return "", nil, err
+	}
+	if len(body) == 0 {
+		return "", nil, NewHTTPError(http.StatusBadRequest, "Empty body")
+	}
 
+	apiVersion, err := detectAdmissionVersion(serializer, body, options.SupportedVersions)
 	if err != nil {
-		// try with beta1, test if this is a beta1 admission
+		return "", nil, err
+	}
+
+	var ar *admissionApi.AdmissionReview
+	if apiVersion == verAdmissionApiBeta1 {
 		arBeta1 := admissionApiBeta1.AdmissionReview{}
-		_, _, err = deserializer.Decode(body, nil, &arBeta1)
-		if err != nil {
+		if _, err = serializer.Decode(body, &arBeta1); err != nil {
 			return "", nil, err
 		}
-
 		ar = beta1tov1AdmissionReview(&arBeta1)
-		apiVersion = verAdmissionApiBeta1
 	} else {
-		ar = &arV1
-		apiVersion = verAdmissionApi
+		ar = &admissionApi.AdmissionReview{}
+		if _, err = serializer.Decode(body, ar); err != nil {
+			return "", nil, err
+		}
 	}
 
-	return apiVersion, ar, err
+	logAdmissionReview(RequestLogger(options.Webhook, ar).V(10), "Received admission review", ar)
+
+	return apiVersion, ar, nil
 }
 
-// CreateErrorResponse create an admission error response
-func CreateErrorResponse(errorDesc string) *admissionApi.AdmissionResponse {
-	return &admissionApi.AdmissionResponse{
-		Result: &metav1.Status{
-			Status:  "Failure",
-			Message: errorDesc,
-		},
+// detectAdmissionVersion detect version of an AdmissionReview from its apiVersion and verify that it is one of
+// `supportedVersions`(e.g. v1 or v1beta1), empty `supportedVersions` means all versions that we know
+func detectAdmissionVersion(serializer AdmissionSerializer, body []byte, supportedVersions []string) (string, error) {
+	probe := runtime.Unknown{}
+	gvk, err := serializer.Decode(body, &probe)
+	if err != nil {
+		return "", NewHTTPError(http.StatusBadRequest, "Invalid admission review: %v", err)
+	}
+	if gvk == nil || gvk.Empty() {
+		return "", NewHTTPError(http.StatusBadRequest, "Missing apiVersion and kind of admission review")
+	}
+	if gvk.Kind != "AdmissionReview" {
+		return "", NewHTTPError(http.StatusBadRequest, "Invalid kind. Received: %v, Expected: AdmissionReview", gvk.Kind)
+	}
+
+	apiVersion := gvk.GroupVersion().String()
+	switch apiVersion {
+	case verAdmissionApi, verAdmissionApiBeta1:
+	default:
+		return "", NewHTTPError(http.StatusBadRequest, "Unknown admission version: %v", apiVersion)
 	}
+
+	if len(supportedVersions) != 0 && !helpers.ContainsString(supportedVersions, gvk.Version) {
+		return "", NewHTTPError(http.StatusBadRequest, "Admission version %v is not supported, supported versions: %v",
+			gvk.Version, strings.Join(supportedVersions, ", "))
+	}
+	return apiVersion, nil
 }
 
-// WriteAdmissionResponse write an AdmissionResponse as a HTTP response
+// CreateErrorResponse create an admission error response, use `DenyResponse` for more control over the response
+func CreateErrorResponse(errorDesc string) *admissionApi.AdmissionResponse {
+	return DenyResponse(errorDesc).Response()
+}
+
+// WriteAdmissionResponse write an AdmissionResponse as a JSON HTTP response
 func WriteAdmissionResponse(
 	writer http.ResponseWriter,
 	apiVersion string,
 	ar *admissionApi.AdmissionReview,
 	response *admissionApi.AdmissionResponse) {
+	WriteAdmissionResponseWithSerializer(writer, GetAdmissionSerializer(jsonMIME), nil, apiVersion, ar, response)
+}
+
+// WriteAdmissionResponseWithSerializer write an AdmissionResponse as a HTTP response that encoded by `serializer`,
+// `webhook` is the webhook that handled the request and may be nil, its name is added to the log lines
+func WriteAdmissionResponseWithSerializer(
+	writer http.ResponseWriter,
+	serializer AdmissionSerializer,
+	webhook AdmissionWebhook,
+	apiVersion string,
+	ar *admissionApi.AdmissionReview,
+	response *admissionApi.AdmissionResponse) {
 	responseAR := admissionApi.AdmissionReview{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "AdmissionReview",
@@ -229,22 +305,29 @@ func WriteAdmissionResponse(
 	}
 
 	var err error
-	var resp []byte
+	var resp bytes.Buffer
 	if apiVersion == verAdmissionApiBeta1 {
-		arBeta1 := v1tobeta1AdmissionReview(&responseAR)
-		resp, err = json.Marshal(&arBeta1)
+		err = serializer.Encode(v1tobeta1AdmissionReview(&responseAR), &resp)
 	} else {
-		resp, err = json.Marshal(responseAR)
+		err = serializer.Encode(&responseAR, &resp)
 	}
 
+	logger := RequestLogger(webhook, ar)
 	if err != nil {
-		log.Errorf("Can't encode the response as JSON: %v", err)
+		logger.Error(err, "Can't encode the response", "mediaType", serializer.MediaType())
 		http.Error(writer, "Failed to encode response", http.StatusInternalServerError)
-	} else if _, err := writer.Write(resp); err != nil {
-		log.Errorf("Failed to write response: %v", err)
-		http.Error(writer, "Failed to send response", http.StatusInternalServerError)
-	} else {
-		log.V(10).Infof("Sent response: %v", string(resp))
+		return
+	}
+
+	writer.Header().Set("Content-Type", serializer.MediaType())
+	if _, err := writer.Write(resp.Bytes()); err != nil {
+		logger.Error(err, "Failed to write response")
+	} else if logger := logger.V(10); logger.Enabled() {
+		logged := responseAR.DeepCopy()
+		logged.Request = ar.Request
+		logged = RedactAdmissionReview(logged)
+		logged.Request = nil
+		logAdmissionReview(logger, "Sent response", logged)
 	}
 }
 
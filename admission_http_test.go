@@ -0,0 +1,170 @@
+package webhook_core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionApi "k8s.io/api/admission/v1"
+)
+
+// newAdmissionHTTPRequest create a request that post `body` to a webhook
+func newAdmissionHTTPRequest(body string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/validate/test", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	return request
+}
+
+func admissionReviewBody(apiVersion, kind string) string {
+	return `{"apiVersion":"` + apiVersion + `","kind":"` + kind + `","request":{` +
+		`"uid":"0f5f1b4e-1b5e-4c54-9b0e-3c1d3a1c2a01","operation":"CREATE",` +
+		`"kind":{"group":"","version":"v1","kind":"Pod"},` +
+		`"resource":{"group":"","version":"v1","resource":"pods"},` +
+		`"namespace":"default","name":"test","object":{"apiVersion":"v1","kind":"Pod"}}}`
+}
+
+func TestReadAdmissionReviewWithOptions(t *testing.T) {
+	tests := []struct {
+		name              string
+		body              string
+		supportedVersions []string
+		statusCode        int
+	}{
+		{name: "v1", body: admissionReviewBody(verAdmissionApi, "AdmissionReview")},
+		{name: "v1beta1", body: admissionReviewBody(verAdmissionApiBeta1, "AdmissionReview")},
+		{name: "unknown version", body: admissionReviewBody("admission.k8s.io/v2", "AdmissionReview"),
+			statusCode: http.StatusBadRequest},
+		{name: "unsupported version", body: admissionReviewBody(verAdmissionApiBeta1, "AdmissionReview"),
+			supportedVersions: []string{"v1"}, statusCode: http.StatusBadRequest},
+		{name: "wrong kind", body: admissionReviewBody(verAdmissionApi, "ConversionReview"),
+			statusCode: http.StatusBadRequest},
+		{name: "missing type", body: `{"request":{}}`, statusCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiVersion, ar, err := ReadAdmissionReviewWithOptions(newAdmissionHTTPRequest(test.body),
+				AdmissionReadOptions{SupportedVersions: test.supportedVersions})
+			if test.statusCode != 0 {
+				if err == nil {
+					t.Fatalf("Expected an error with status %d", test.statusCode)
+				}
+				if code := GetHTTPStatusCode(err, 0); code != test.statusCode {
+					t.Errorf("Expected status %d, got %d(%v)", test.statusCode, code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadAdmissionReviewWithOptions failed: %v", err)
+			}
+			if ar.Request == nil || ar.Request.UID != "0f5f1b4e-1b5e-4c54-9b0e-3c1d3a1c2a01" ||
+				ar.Request.Kind.Kind != "Pod" || ar.Request.Name != "test" {
+				t.Errorf("Request is not decoded correctly: %+v", ar.Request)
+			}
+			if !strings.HasSuffix(apiVersion, "/"+test.name) {
+				t.Errorf("Expected version %s, got %s", test.name, apiVersion)
+			}
+		})
+	}
+}
+
+func TestAdmissionReviewRoundTrip(t *testing.T) {
+	for _, apiVersion := range []string{verAdmissionApi, verAdmissionApiBeta1} {
+		t.Run(apiVersion, func(t *testing.T) {
+			version, ar, err := ReadAdmissionReviewWithOptions(
+				newAdmissionHTTPRequest(admissionReviewBody(apiVersion, "AdmissionReview")), AdmissionReadOptions{})
+			if err != nil {
+				t.Fatalf("ReadAdmissionReviewWithOptions failed: %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			WriteAdmissionResponse(recorder, version, ar,
+				DenyResponse("not allowed").WithWarnings("first", "second").Response())
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("Unexpected status %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			var written struct {
+				APIVersion string `json:"apiVersion"`
+				Kind       string `json:"kind"`
+				Response   struct {
+					UID      string   `json:"uid"`
+					Allowed  bool     `json:"allowed"`
+					Warnings []string `json:"warnings"`
+				} `json:"response"`
+			}
+			if err = json.Unmarshal(recorder.Body.Bytes(), &written); err != nil {
+				t.Fatalf("Invalid response %s: %v", recorder.Body.String(), err)
+			}
+			if written.APIVersion != apiVersion || written.Kind != "AdmissionReview" {
+				t.Errorf("Response must be a %s AdmissionReview, got %s %s", apiVersion, written.APIVersion, written.Kind)
+			}
+			if written.Response.UID != string(ar.Request.UID) {
+				t.Errorf("Expected uid %s, got %s", ar.Request.UID, written.Response.UID)
+			}
+			if written.Response.Allowed {
+				t.Error("Denial is encoded as allowed")
+			}
+			if !reflect.DeepEqual(written.Response.Warnings, []string{"first", "second"}) {
+				t.Errorf("Unexpected warnings: %v", written.Response.Warnings)
+			}
+		})
+	}
+}
+
+func TestAdmissionHandlerRejectInvalidBodies(t *testing.T) {
+	large := strings.Repeat(" ", DefaultMaxBodySize+1)
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		streamed    bool
+		statusCode  int
+	}{
+		{name: "valid", body: admissionReviewBody(verAdmissionApi, "AdmissionReview"),
+			contentType: "application/json; charset=UTF-8", statusCode: http.StatusOK},
+		{name: "too large", body: large, contentType: "application/json", statusCode: http.StatusRequestEntityTooLarge},
+		{name: "too large without content-length", body: large, contentType: "application/json", streamed: true,
+			statusCode: http.StatusRequestEntityTooLarge},
+		{name: "missing content-type", body: admissionReviewBody(verAdmissionApi, "AdmissionReview"),
+			statusCode: http.StatusUnsupportedMediaType},
+		{name: "malformed content-type", body: admissionReviewBody(verAdmissionApi, "AdmissionReview"),
+			contentType: "application/json; charset", statusCode: http.StatusUnsupportedMediaType},
+		{name: "unsupported content-type", body: admissionReviewBody(verAdmissionApi, "AdmissionReview"),
+			contentType: "text/plain", statusCode: http.StatusUnsupportedMediaType},
+		{name: "non utf-8 charset", body: admissionReviewBody(verAdmissionApi, "AdmissionReview"),
+			contentType: "application/json; charset=iso-8859-1", statusCode: http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			webhook := newTestWebhook("test", ValidatingAdmissionWebhook,
+				func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+					called = true
+					return AllowResponse().Response(), nil
+				})
+			handler := admissionHandlerFunc(&CLICommand{Registry: NewWebhookRegistry(webhook)}, webhook, nil, nil)
+
+			request := httptest.NewRequest(http.MethodPost, "/validate/test", strings.NewReader(test.body))
+			if test.streamed {
+				request.Body = ioutil.NopCloser(strings.NewReader(test.body))
+				request.ContentLength = -1
+			}
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.statusCode {
+				t.Errorf("Expected status %d, got %d: %s", test.statusCode, recorder.Code, recorder.Body.String())
+			}
+			if called != (test.statusCode == http.StatusOK) {
+				t.Errorf("Webhook must only be called for valid requests, called=%v", called)
+			}
+		})
+	}
+}
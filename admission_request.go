@@ -0,0 +1,258 @@
+package webhook_core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	admissionApi "k8s.io/api/admission/v1"
+	authenticationApi "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AdmissionRequestHandler webhooks that implement this interface receive an `AdmissionRequest` and their
+// `HandleAdmission` will not be called by the server
+type AdmissionRequestHandler interface {
+	// HandleAdmissionRequest handle an admission request and return its response
+	HandleAdmissionRequest(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error)
+}
+
+// AdmissionRequest wrap an admission review that received by a webhook and provide helpers to inspect it and to
+// build its response
+type AdmissionRequest struct {
+	// HTTPRequest HTTP request that contains this admission review
+	HTTPRequest *http.Request
+	// Review admission review that received in the request
+	Review *admissionApi.AdmissionReview
+	// Webhook webhook that is handling this request
+	Webhook AdmissionWebhook
+	// Clients provider of kubernetes clients
+	Clients ClientProvider
+
+	warnings         []string
+	auditAnnotations map[string]string
+	object           runtime.Object
+	oldObject        runtime.Object
+}
+
+// NewAdmissionRequest create a new `AdmissionRequest`
+func NewAdmissionRequest(
+	httpRequest *http.Request,
+	review *admissionApi.AdmissionReview,
+	webhook AdmissionWebhook,
+	clients ClientProvider) *AdmissionRequest {
+	return &AdmissionRequest{
+		HTTPRequest: httpRequest,
+		Review:      review,
+		Webhook:     webhook,
+		Clients:     clients,
+	}
+}
+
+// Context get context of this request, it is cancelled when the client disconnect or deadline of the request reached
+func (this *AdmissionRequest) Context() context.Context {
+	if this.HTTPRequest == nil {
+		return context.Background()
+	}
+	return this.HTTPRequest.Context()
+}
+
+// Logger get a logger that add identity of this request to its lines
+func (this *AdmissionRequest) Logger() logr.Logger { return RequestLogger(this.Webhook, this.Review) }
+
+// Request get actual admission request
+func (this *AdmissionRequest) Request() *admissionApi.AdmissionRequest {
+	if this.Review == nil || this.Review.Request == nil {
+		return &admissionApi.AdmissionRequest{}
+	}
+	return this.Review.Request
+}
+
+// UID get unique identifier of this request
+func (this *AdmissionRequest) UID() types.UID { return this.Request().UID }
+
+// Operation get operation that is being performed
+func (this *AdmissionRequest) Operation() admissionApi.Operation { return this.Request().Operation }
+
+// Kind get fully-qualified kind of the object that is being admitted
+func (this *AdmissionRequest) Kind() schema.GroupVersionKind {
+	kind := this.Request().Kind
+	return schema.GroupVersionKind{Group: kind.Group, Version: kind.Version, Kind: kind.Kind}
+}
+
+// Resource get fully-qualified resource that is being requested
+func (this *AdmissionRequest) Resource() schema.GroupVersionResource {
+	resource := this.Request().Resource
+	return schema.GroupVersionResource{Group: resource.Group, Version: resource.Version, Resource: resource.Resource}
+}
+
+// SubResource get subresource that is being requested, if any
+func (this *AdmissionRequest) SubResource() string { return this.Request().SubResource }
+
+// Namespace get namespace of the object
+func (this *AdmissionRequest) Namespace() string { return this.Request().Namespace }
+
+// Name get name of the object, it may be empty for CREATE operations when name is generated by the server
+func (this *AdmissionRequest) Name() string { return this.Request().Name }
+
+// UserInfo get information about the user that sent the request
+func (this *AdmissionRequest) UserInfo() authenticationApi.UserInfo { return this.Request().UserInfo }
+
+// IsDryRun check if this request is a dry run, webhooks with side effects must not perform them in this case
+func (this *AdmissionRequest) IsDryRun() bool {
+	dryRun := this.Request().DryRun
+	return dryRun != nil && *dryRun
+}
+
+func decodeRawObject(raw runtime.RawExtension) (runtime.Object, error) {
+	if raw.Object != nil {
+		return raw.Object, nil
+	}
+	if len(raw.Raw) == 0 {
+		return nil, nil
+	}
+
+	obj, _, err := deserializer.Decode(raw.Raw, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		unstructuredObj := &unstructured.Unstructured{}
+		if err = unstructuredObj.UnmarshalJSON(raw.Raw); err != nil {
+			return nil, err
+		}
+		return unstructuredObj, nil
+	}
+	return obj, err
+}
+func decodeRawObjectInto(raw runtime.RawExtension, into runtime.Object) error {
+	if len(raw.Raw) == 0 {
+		return fmt.Errorf("Request does not contain the object")
+	}
+	_, _, err := deserializer.Decode(raw.Raw, nil, into)
+	return err
+}
+
+// Object get the object that is being admitted, objects with kinds that are not registered in `Scheme` are
+// returned as `*unstructured.Unstructured`. It is nil for DELETE operations.
+func (this *AdmissionRequest) Object() (runtime.Object, error) {
+	if this.object == nil {
+		obj, err := decodeRawObject(this.Request().Object)
+		if err != nil {
+			return nil, err
+		}
+		this.object = obj
+	}
+	return this.object, nil
+}
+
+// OldObject get existing object, it is only available for UPDATE and DELETE operations
+func (this *AdmissionRequest) OldObject() (runtime.Object, error) {
+	if this.oldObject == nil {
+		obj, err := decodeRawObject(this.Request().OldObject)
+		if err != nil {
+			return nil, err
+		}
+		this.oldObject = obj
+	}
+	return this.oldObject, nil
+}
+
+// DecodeObject decode the object that is being admitted into `into`
+func (this *AdmissionRequest) DecodeObject(into runtime.Object) error {
+	return decodeRawObjectInto(this.Request().Object, into)
+}
+
+// DecodeOldObject decode existing object into `into`
+func (this *AdmissionRequest) DecodeOldObject(into runtime.Object) error {
+	return decodeRawObjectInto(this.Request().OldObject, into)
+}
+
+// ObjectMeta get metadata of the object that is being admitted, for DELETE operations metadata of the old object
+// will be returned
+func (this *AdmissionRequest) ObjectMeta() (metav1.Object, error) {
+	obj, err := this.Object()
+	if err == nil && obj == nil {
+		obj, err = this.OldObject()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("Request does not contain any object")
+	}
+	return meta.Accessor(obj)
+}
+
+// Warn add a warning to the response of this request
+func (this *AdmissionRequest) Warn(format string, args ...interface{}) {
+	this.warnings = append(this.warnings, fmt.Sprintf(format, args...))
+}
+
+// SetAuditAnnotation set an annotation in the audit record of this request
+func (this *AdmissionRequest) SetAuditAnnotation(key, value string) {
+	if this.auditAnnotations == nil {
+		this.auditAnnotations = make(map[string]string)
+	}
+	this.auditAnnotations[key] = value
+}
+
+// Complete add warnings and audit annotations of this request to the response, it is safe to call it more than once
+func (this *AdmissionRequest) Complete(response *admissionApi.AdmissionResponse) *admissionApi.AdmissionResponse {
+	if response == nil {
+		return nil
+	}
+	for _, warning := range this.warnings {
+		found := false
+		for _, existing := range response.Warnings {
+			if existing == warning {
+				found = true
+				break
+			}
+		}
+		if !found {
+			response.Warnings = append(response.Warnings, warning)
+		}
+	}
+	if len(this.auditAnnotations) != 0 {
+		if response.AuditAnnotations == nil {
+			response.AuditAnnotations = make(map[string]string)
+		}
+		for key, value := range this.auditAnnotations {
+			response.AuditAnnotations[key] = value
+		}
+	}
+	return response
+}
+
+// Respond build the response from `builder` and add warnings and audit annotations of this request to it
+func (this *AdmissionRequest) Respond(builder *ResponseBuilder) *admissionApi.AdmissionResponse {
+	return this.Complete(builder.Response())
+}
+
+// Allow create a response that allow this request
+func (this *AdmissionRequest) Allow() *admissionApi.AdmissionResponse {
+	return this.Respond(AllowResponse())
+}
+
+// Deny create a response that deny this request
+func (this *AdmissionRequest) Deny(message string) *admissionApi.AdmissionResponse {
+	return this.Respond(DenyResponse(message))
+}
+
+// Denyf create a response that deny this request with a formatted message
+func (this *AdmissionRequest) Denyf(format string, args ...interface{}) *admissionApi.AdmissionResponse {
+	return this.Respond(DenyResponsef(format, args...))
+}
+
+// Patch create a response that allow this request and apply `patches` to the object
+func (this *AdmissionRequest) Patch(patches []PatchOperation) (*admissionApi.AdmissionResponse, error) {
+	response, err := CreatePatchResponse(patches)
+	if err != nil {
+		return nil, err
+	}
+	return this.Complete(response), nil
+}
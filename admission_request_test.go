@@ -0,0 +1,127 @@
+package webhook_core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	admissionApi "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const testWidget = `{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"name":"widget","namespace":"default",` +
+	`"labels":{"size":"large"}},"spec":{"replicas":2}}`
+
+// newTestAdmissionRequest create a request with the raw object and old object
+func newTestAdmissionRequest(operation admissionApi.Operation, object, oldObject string) *AdmissionRequest {
+	review := &admissionApi.AdmissionReview{
+		Request: &admissionApi.AdmissionRequest{
+			UID:       "test-uid",
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: []byte(object)},
+			OldObject: runtime.RawExtension{Raw: []byte(oldObject)},
+		},
+	}
+	webhook := newTestWebhook("test", ValidatingAdmissionWebhook, nil)
+	return NewAdmissionRequest(httptest.NewRequest(http.MethodPost, "/", nil), review, webhook, nil)
+}
+
+func TestAdmissionRequestObject(t *testing.T) {
+	request := newTestAdmissionRequest(admissionApi.Update, testPod, testWidget)
+
+	object, err := request.Object()
+	if err != nil {
+		t.Fatalf("Failed to decode the object: %v", err)
+	}
+	if pod, ok := object.(*corev1.Pod); !ok || pod.Name != "test" {
+		t.Errorf("Expected a typed Pod, got %T", object)
+	}
+	if again, _ := request.Object(); again != object {
+		t.Error("Decoded object must be reused")
+	}
+
+	oldObject, err := request.OldObject()
+	if err != nil {
+		t.Fatalf("Failed to decode the old object: %v", err)
+	}
+	widget, ok := oldObject.(*unstructured.Unstructured)
+	if !ok {
+		t.Fatalf("Expected an unstructured object for an unregistered kind, got %T", oldObject)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(widget.Object, "spec", "replicas"); replicas != 2 {
+		t.Errorf("Unexpected content of the unstructured object: %v", widget.Object)
+	}
+
+	var pod corev1.Pod
+	if err = request.DecodeObject(&pod); err != nil || pod.Namespace != "default" {
+		t.Errorf("DecodeObject failed: %v", err)
+	}
+}
+
+func TestAdmissionRequestObjectMeta(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionApi.Operation
+		object    string
+		oldObject string
+		expected  string
+	}{
+		{name: "create", operation: admissionApi.Create, object: testPod, expected: "test"},
+		{name: "delete use old object", operation: admissionApi.Delete, oldObject: testWidget, expected: "widget"},
+		{name: "no object", operation: admissionApi.Connect},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objectMeta, err := newTestAdmissionRequest(test.operation, test.object, test.oldObject).ObjectMeta()
+			if test.expected == "" {
+				if err == nil {
+					t.Error("Expected an error for a request without object")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ObjectMeta failed: %v", err)
+			}
+			if objectMeta.GetName() != test.expected || objectMeta.GetNamespace() != "default" {
+				t.Errorf("Unexpected metadata %s/%s", objectMeta.GetNamespace(), objectMeta.GetName())
+			}
+		})
+	}
+}
+
+func TestAdmissionRequestResponses(t *testing.T) {
+	request := newTestAdmissionRequest(admissionApi.Create, testPod, "")
+	request.Warn("field %s is deprecated", "spec.foo")
+	request.SetAuditAnnotation("rule", "no-foo")
+
+	denial := request.Denyf("%s is not allowed", "foo")
+	if denial.Allowed || denial.Result == nil || denial.Result.Message != "foo is not allowed" {
+		t.Errorf("Unexpected denial %+v", denial)
+	}
+	if !reflect.DeepEqual(denial.Warnings, []string{"field spec.foo is deprecated"}) {
+		t.Errorf("Warnings are not added to the denial: %v", denial.Warnings)
+	}
+	if denial.AuditAnnotations["rule"] != "no-foo" {
+		t.Errorf("Audit annotations are not added to the denial: %v", denial.AuditAnnotations)
+	}
+
+	patch, err := request.Patch([]PatchOperation{NewAddPatch("/metadata/labels", map[string]string{"a": "b"})})
+	if err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	if !patch.Allowed || patch.PatchType == nil || *patch.PatchType != admissionApi.PatchTypeJSONPatch {
+		t.Errorf("Expected an allowed JSONPatch response, got %+v", patch)
+	}
+	if string(patch.Patch) != `[{"op":"add","path":"/metadata/labels","value":{"a":"b"}}]` {
+		t.Errorf("Unexpected patch %s", patch.Patch)
+	}
+
+	// completing a response more than once must not duplicate warnings
+	completed := request.Complete(patch)
+	if len(completed.Warnings) != 1 || completed.AuditAnnotations["rule"] != "no-foo" {
+		t.Errorf("Unexpected completed response %+v", completed)
+	}
+}
@@ -2,10 +2,13 @@ package webhook_core
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
 
-	log "github.com/golang/glog"
 	admissionApi "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 const (
@@ -24,10 +27,7 @@ const (
 )
 
 var (
-	jsonPatch  = admissionApi.PatchTypeJSONPatch
-	okResponse = admissionApi.AdmissionResponse{
-		Allowed: true,
-	}
+	jsonPatch = admissionApi.PatchTypeJSONPatch
 
 	// IgnoredNamespaces list of namespaces that should ignored by admission review process
 	IgnoredNamespaces = []string{
@@ -125,7 +125,8 @@ func CreatePatchResponse(patches []PatchOperation) (*admissionApi.AdmissionRespo
 			return nil, err
 		}
 
-		log.Infof("Converting %v to JSONPatch", string(patchBytes))
+		// content of the patch is logged with the response, where patches of Secrets are redacted
+		GetLogger().V(10).Info("Converting to JSONPatch", "operations", len(patches))
 		return &admissionApi.AdmissionResponse{
 			Allowed:   true,
 			Patch:     patchBytes,
@@ -133,6 +134,144 @@ func CreatePatchResponse(patches []PatchOperation) (*admissionApi.AdmissionRespo
 		}, nil
 	} else {
 		// nothing to patch
-		return &okResponse, nil
+		return &admissionApi.AdmissionResponse{Allowed: true}, nil
+	}
+}
+
+// ResponseBuilder build an admission response step by step
+type ResponseBuilder struct {
+	response *admissionApi.AdmissionResponse
+}
+
+// AllowResponse start building a response that allow the request
+func AllowResponse() *ResponseBuilder {
+	return &ResponseBuilder{
+		response: &admissionApi.AdmissionResponse{Allowed: true},
+	}
+}
+
+// DenyResponse start building a response that deny the request, by default code of the response is 403 and its
+// reason is `Forbidden`
+func DenyResponse(message string) *ResponseBuilder {
+	return &ResponseBuilder{
+		response: &admissionApi.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: message,
+				Code:    http.StatusForbidden,
+				Reason:  metav1.StatusReasonForbidden,
+			},
+		},
+	}
+}
+
+// DenyResponsef start building a response that deny the request with a formatted message
+func DenyResponsef(format string, args ...interface{}) *ResponseBuilder {
+	return DenyResponse(fmt.Sprintf(format, args...))
+}
+
+func (this *ResponseBuilder) result() *metav1.Status {
+	if this.response.Result == nil {
+		this.response.Result = &metav1.Status{}
+		if this.response.Allowed {
+			this.response.Result.Status = metav1.StatusSuccess
+		} else {
+			this.response.Result.Status = metav1.StatusFailure
+		}
+	}
+	return this.response.Result
+}
+func (this *ResponseBuilder) details() *metav1.StatusDetails {
+	result := this.result()
+	if result.Details == nil {
+		result.Details = &metav1.StatusDetails{}
+	}
+	return result.Details
+}
+
+// WithCode set HTTP code of the response
+func (this *ResponseBuilder) WithCode(code int32) *ResponseBuilder {
+	this.result().Code = code
+	return this
+}
+
+// WithReason set machine readable reason of the response
+func (this *ResponseBuilder) WithReason(reason metav1.StatusReason) *ResponseBuilder {
+	this.result().Reason = reason
+	return this
+}
+
+// WithMessage set human readable message of the response
+func (this *ResponseBuilder) WithMessage(message string) *ResponseBuilder {
+	this.result().Message = message
+	return this
+}
+
+// WithDetails set the object that this response is about
+func (this *ResponseBuilder) WithDetails(kind schema.GroupKind, name string) *ResponseBuilder {
+	details := this.details()
+	details.Group = kind.Group
+	details.Kind = kind.Kind
+	details.Name = name
+	return this
+}
+
+// WithCause add a cause to the details of the response, `field` is the path of the field that caused the problem
+func (this *ResponseBuilder) WithCause(causeType metav1.CauseType, field string, message string) *ResponseBuilder {
+	details := this.details()
+	details.Causes = append(details.Causes, metav1.StatusCause{
+		Type:    causeType,
+		Message: message,
+		Field:   field,
+	})
+	return this
+}
+
+// WithFieldErrors add a cause for each field error
+func (this *ResponseBuilder) WithFieldErrors(errs field.ErrorList) *ResponseBuilder {
+	for _, err := range errs {
+		this.WithCause(metav1.CauseType(err.Type), err.Field, err.ErrorBody())
+	}
+	return this
+}
+
+// WithWarnings add warnings to the response, warnings are shown to the user by the client
+func (this *ResponseBuilder) WithWarnings(warnings ...string) *ResponseBuilder {
+	this.response.Warnings = append(this.response.Warnings, warnings...)
+	return this
+}
+
+// WithAuditAnnotation add an annotation to the audit record of this request
+func (this *ResponseBuilder) WithAuditAnnotation(key, value string) *ResponseBuilder {
+	if this.response.AuditAnnotations == nil {
+		this.response.AuditAnnotations = make(map[string]string)
 	}
+	this.response.AuditAnnotations[key] = value
+	return this
+}
+
+// Response get the built response
+func (this *ResponseBuilder) Response() *admissionApi.AdmissionResponse {
+	return this.response
+}
+
+// CreateAllowedWithWarningsResponse create a response that allow the request but show warnings to the user
+func CreateAllowedWithWarningsResponse(warnings ...string) *admissionApi.AdmissionResponse {
+	return AllowResponse().WithWarnings(warnings...).Response()
+}
+
+// CreateDenyResponse create a response that deny the request with specified code and reason
+func CreateDenyResponse(code int32, reason metav1.StatusReason, message string) *admissionApi.AdmissionResponse {
+	return DenyResponse(message).WithCode(code).WithReason(reason).Response()
+}
+
+// CreateInvalidResponse create a response that deny the request because some fields of the object are invalid
+func CreateInvalidResponse(kind schema.GroupKind, name string, errs field.ErrorList) *admissionApi.AdmissionResponse {
+	return DenyResponsef("%s %q is invalid: %v", kind.String(), name, errs.ToAggregate()).
+		WithCode(http.StatusUnprocessableEntity).
+		WithReason(metav1.StatusReasonInvalid).
+		WithDetails(kind, name).
+		WithFieldErrors(errs).
+		Response()
 }
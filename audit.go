@@ -0,0 +1,432 @@
+package webhook_core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devops-simba/helpers"
+	admissionApi "k8s.io/api/admission/v1"
+)
+
+// AuditRecord an admission decision that is written to the audit sinks
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	UID         string    `json:"uid"`
+	User        string    `json:"user"`
+	Groups      []string  `json:"groups,omitempty"`
+	Operation   string    `json:"operation"`
+	Resource    string    `json:"resource"`
+	SubResource string    `json:"subResource,omitempty"`
+	Kind        string    `json:"kind"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	DryRun      bool      `json:"dryRun,omitempty"`
+	Webhook     string    `json:"webhook"`
+	// State state of the webhook in the registry, e.g. enabled or disabled
+	State WebhookState `json:"state"`
+	// EnforcementMode enforcement mode that applied to the decision of the webhook
+	EnforcementMode EnforcementMode `json:"enforcementMode,omitempty"`
+	// PolicyAllowed decision of the webhook before its enforcement mode applied
+	PolicyAllowed bool `json:"policyAllowed"`
+	// Allowed decision that sent to the API server
+	Allowed  bool     `json:"allowed"`
+	Code     int32    `json:"code,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Patch    string   `json:"patch,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// Error error of the webhook, if it failed to handle the request
+	Error string `json:"error,omitempty"`
+}
+
+// NewAuditRecord create an audit record for a decision of a webhook. `policyResponse` is response of the webhook
+// before its enforcement mode applied and `response` is the response that sent to the API server, any of them may
+// be nil if webhook failed.
+func NewAuditRecord(
+	webhook AdmissionWebhook,
+	ar *admissionApi.AdmissionReview,
+	policyResponse *admissionApi.AdmissionResponse,
+	response *admissionApi.AdmissionResponse,
+	err error) *AuditRecord {
+	record := &AuditRecord{Time: time.Now().UTC(), Webhook: webhook.Name()}
+	if ar != nil && ar.Request != nil {
+		request := ar.Request
+		record.UID = string(request.UID)
+		record.User = request.UserInfo.Username
+		record.Groups = request.UserInfo.Groups
+		record.Operation = string(request.Operation)
+		record.Resource = request.Resource.Resource
+		if request.Resource.Group != "" {
+			record.Resource += "." + request.Resource.Group
+		}
+		record.SubResource = request.SubResource
+		record.Kind = formatRequestKind(request.Kind)
+		record.Namespace = request.Namespace
+		record.Name = request.Name
+		record.DryRun = request.DryRun != nil && *request.DryRun
+	}
+	if policyResponse != nil {
+		record.PolicyAllowed = policyResponse.Allowed
+	}
+	if response != nil {
+		record.Allowed = response.Allowed
+		record.Warnings = response.Warnings
+		if response.Result != nil {
+			record.Code = response.Result.Code
+			record.Reason = string(response.Result.Reason)
+			record.Message = response.Result.Message
+		}
+		if policyResponse != nil && !policyResponse.Allowed && response.Allowed {
+			record.Message = getResponseMessage(policyResponse)
+		}
+		record.Patch = redactPatch(ar, response.Patch)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// AuditSink destination of the audit records
+type AuditSink interface {
+	// Write write a record to the sink, it should not block the admission for a long time
+	Write(record *AuditRecord) error
+	// Close flush pending records and close the sink
+	Close() error
+}
+
+// writerAuditSink write records as JSON lines to a writer
+type writerAuditSink struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+// NewWriterAuditSink create a sink that write each record as a JSON line to `writer`, e.g. `os.Stdout`
+func NewWriterAuditSink(writer io.Writer) AuditSink { return &writerAuditSink{writer: writer} }
+
+func (this *writerAuditSink) Write(record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, err = this.writer.Write(append(data, '\n'))
+	return err
+}
+func (this *writerAuditSink) Close() error { return nil }
+
+// fileAuditSink write records as JSON lines to a file and rotate it when it become too large
+type fileAuditSink struct {
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileAuditSink create a sink that write each record as a JSON line to a file, when size of the file exceed
+// `maxSize` it is renamed to `path.1`(older backups are shifted up to `path.<maxBackups>`) and a new file is created.
+// Zero `maxSize` disable rotation.
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) (AuditSink, error) {
+	result := &fileAuditSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := result.open(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *fileAuditSink) open() error {
+	file, err := os.OpenFile(this.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	this.file, this.size = file, info.Size()
+	return nil
+}
+
+// rotate move current file to the backups and open a new one, original file is reopened if this fail, so the sink
+// remain usable
+func (this *fileAuditSink) rotate() error {
+	err := this.file.Close()
+	this.file = nil
+	if err == nil {
+		err = this.moveToBackups()
+	}
+	if openErr := this.open(); openErr != nil && err == nil {
+		err = openErr
+	}
+	return err
+}
+func (this *fileAuditSink) moveToBackups() error {
+	if this.maxBackups <= 0 {
+		if err := os.Remove(this.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	for i := this.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", this.path, i), fmt.Sprintf("%s.%d", this.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(this.path, this.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+func (this *fileAuditSink) Write(record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.file == nil {
+		return errors.New("Audit file is closed")
+	}
+	if this.maxSize > 0 && this.size > 0 && this.size+int64(len(data)) > this.maxSize {
+		if err = this.rotate(); err != nil {
+			GetLogger().Error(err, "Failed to rotate audit file", "path", this.path)
+			if this.file == nil {
+				return err
+			}
+		}
+	}
+	n, err := this.file.Write(data)
+	this.size += int64(n)
+	return err
+}
+func (this *fileAuditSink) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.file == nil {
+		return nil
+	}
+	err := this.file.Close()
+	this.file = nil
+	return err
+}
+
+// httpAuditSink send records to an HTTP collector in batches
+type httpAuditSink struct {
+	url       string
+	client    *http.Client
+	batchSize int
+	interval  time.Duration
+	lock      sync.RWMutex
+	closed    bool
+	records   chan *AuditRecord
+	done      chan struct{}
+}
+
+// DefaultAuditQueueSize number of the records that an HTTP audit sink keep in memory before dropping new records
+const DefaultAuditQueueSize = 10000
+
+// NewHTTPAuditSink create a sink that POST records to `url` as JSON lines(application/x-ndjson). Records are queued
+// and sent in batches of at most `batchSize` records at least every `interval`, so the collector does not slow down
+// the admissions. Records are dropped if the queue is full.
+func NewHTTPAuditSink(url string, timeout time.Duration, batchSize int, interval time.Duration) AuditSink {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	result := &httpAuditSink{
+		url:       url,
+		client:    &http.Client{Timeout: timeout},
+		batchSize: batchSize,
+		interval:  interval,
+		records:   make(chan *AuditRecord, DefaultAuditQueueSize),
+		done:      make(chan struct{}),
+	}
+	go result.run()
+	return result
+}
+
+func (this *httpAuditSink) run() {
+	defer close(this.done)
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	batch := make([]*AuditRecord, 0, this.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := this.send(batch); err != nil {
+			GetLogger().Error(err, "Failed to send audit records", "url", this.url, "count", len(batch))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case record, ok := <-this.records:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= this.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+func (this *httpAuditSink) send(batch []*AuditRecord) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, record := range batch {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	response, err := this.client.Post(this.url, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("Audit collector replied with %s", response.Status)
+	}
+	return nil
+}
+func (this *httpAuditSink) Write(record *AuditRecord) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if this.closed {
+		return errors.New("Audit sink is closed")
+	}
+	select {
+	case this.records <- record:
+		return nil
+	default:
+		return errors.New("Audit queue is full, record dropped")
+	}
+}
+func (this *httpAuditSink) Close() error {
+	this.lock.Lock()
+	if !this.closed {
+		this.closed = true
+		close(this.records)
+	}
+	this.lock.Unlock()
+
+	<-this.done
+	return nil
+}
+
+// AuditSinks write records to several sinks
+type AuditSinks []AuditSink
+
+func (this AuditSinks) Write(record *AuditRecord) error {
+	errBuilder := helpers.AggregateErrorBuilder{}
+	for _, sink := range this {
+		if err := sink.Write(record); err != nil {
+			errBuilder.AddError(err)
+		}
+	}
+	return errBuilder.GetError()
+}
+func (this AuditSinks) Close() error {
+	errBuilder := helpers.AggregateErrorBuilder{}
+	for _, sink := range this {
+		if err := sink.Close(); err != nil {
+			errBuilder.AddError(err)
+		}
+	}
+	return errBuilder.GetError()
+}
+
+// AuditOptions options of the audit sinks of the command
+type AuditOptions struct {
+	// Sinks specification of the sinks: `stdout`, `file:<path>` or an http(s) URL of a collector
+	Sinks []string
+	// MaxFileSize maximum size of an audit file in bytes before it is rotated
+	MaxFileSize int64
+	// MaxBackups number of the rotated audit files that are kept
+	MaxBackups int
+	// HTTPTimeout timeout of sending records to an HTTP collector
+	HTTPTimeout time.Duration
+}
+
+type auditSinkList []string
+
+func (this *auditSinkList) String() string { return strings.Join(*this, ",") }
+func (this *auditSinkList) Set(value string) error {
+	*this = append(*this, value)
+	return nil
+}
+
+// BindToFlags bind these options to command line flags
+func (this *AuditOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.Var((*auditSinkList)(&this.Sinks), "audit-sink",
+		"Sink of the admission decisions, one of stdout, file:<path> or an http(s) URL of a collector, may be repeated")
+	flagset.Int64Var(&this.MaxFileSize, "audit-file-max-size", 100*1024*1024,
+		"Maximum size of an audit file in bytes before it is rotated, 0 disable rotation")
+	flagset.IntVar(&this.MaxBackups, "audit-file-max-backups", 5, "Number of the rotated audit files that are kept")
+	flagset.DurationVar(&this.HTTPTimeout, "audit-http-timeout", 10*time.Second,
+		"Timeout of sending audit records to an HTTP collector")
+}
+
+// CreateAuditSink create an audit sink from a specification: `stdout`, `file:<path>` or an http(s) URL
+func (this AuditOptions) CreateAuditSink(spec string) (AuditSink, error) {
+	switch {
+	case spec == "stdout":
+		return NewWriterAuditSink(os.Stdout), nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileAuditSink(strings.TrimPrefix(spec, "file:"), this.MaxFileSize, this.MaxBackups)
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return NewHTTPAuditSink(spec, this.HTTPTimeout, 0, 0), nil
+	default:
+		return nil, fmt.Errorf("Invalid audit sink(%s), expected stdout, file:<path> or an http(s) URL", spec)
+	}
+}
+
+// CreateAuditSinks create all sinks of these options, it return nil if there is no sink
+func (this AuditOptions) CreateAuditSinks() (AuditSink, error) {
+	if len(this.Sinks) == 0 {
+		return nil, nil
+	}
+
+	var result AuditSinks
+	for _, spec := range this.Sinks {
+		sink, err := this.CreateAuditSink(spec)
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+		result = append(result, sink)
+	}
+	return result, nil
+}
@@ -0,0 +1,215 @@
+package webhook_core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readAuditFile read UID of the records of an audit file
+func readAuditFile(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record AuditRecord
+		if err = json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid audit line %s: %v", line, err)
+		}
+		result = append(result, record.UID)
+	}
+	return result
+}
+
+func newAuditFileDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestFileAuditSinkRotate(t *testing.T) {
+	path := filepath.Join(newAuditFileDir(t), "audit.log")
+	// each file can only keep one record
+	sink, err := NewFileAuditSink(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for _, uid := range []string{"1", "2", "3", "4"} {
+		if err = sink.Write(&AuditRecord{UID: uid}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	expected := map[string]string{path: "4", path + ".1": "3", path + ".2": "2"}
+	for file, uid := range expected {
+		if uids := readAuditFile(t, file); len(uids) != 1 || uids[0] != uid {
+			t.Errorf("Expected record %s in %s, got %v", uid, file, uids)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Only 2 backups must be kept")
+	}
+}
+
+func TestFileAuditSinkRotateFailure(t *testing.T) {
+	path := filepath.Join(newAuditFileDir(t), "audit.log")
+	// a directory in place of the backup make the rotation fail
+	if err := os.Mkdir(path+".1", 0700); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewFileAuditSink(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for _, uid := range []string{"1", "2"} {
+		if err = sink.Write(&AuditRecord{UID: uid}); err != nil {
+			t.Fatalf("Records must be written to the current file when rotation fail: %v", err)
+		}
+	}
+	if uids := readAuditFile(t, path); strings.Join(uids, ",") != "1,2" {
+		t.Errorf("Expected both records in the current file, got %v", uids)
+	}
+
+	if err = os.Remove(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Write(&AuditRecord{UID: "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if uids := readAuditFile(t, path+".1"); strings.Join(uids, ",") != "1,2" {
+		t.Errorf("Expected rotation to resume, got %v in the backup", uids)
+	}
+}
+
+// auditCollector an HTTP collector that keep UID of the records of each batch
+type auditCollector struct {
+	lock    sync.Mutex
+	batches [][]string
+	release chan struct{}
+}
+
+func (this *auditCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if this.release != nil {
+		<-this.release
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		http.Error(w, "Unexpected content type "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var batch []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, record.UID)
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.batches = append(this.batches, batch)
+}
+func (this *auditCollector) Batches() [][]string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([][]string{}, this.batches...)
+}
+func (this *auditCollector) Count() int {
+	count := 0
+	for _, batch := range this.Batches() {
+		count += len(batch)
+	}
+	return count
+}
+
+func TestHTTPAuditSinkBatches(t *testing.T) {
+	collector := &auditCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	sink := NewHTTPAuditSink(server.URL, time.Second, 2, time.Hour)
+	for i := 1; i <= 5; i++ {
+		if err := sink.Write(&AuditRecord{UID: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the last incomplete batch is flushed on close
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if batches := fmt.Sprint(collector.Batches()); batches != "[[1 2] [3 4] [5]]" {
+		t.Errorf("Unexpected batches %s", batches)
+	}
+	if err := sink.Write(&AuditRecord{UID: "6"}); err == nil {
+		t.Error("Expected an error when writing to a closed sink")
+	}
+}
+
+func TestHTTPAuditSinkFlushOnInterval(t *testing.T) {
+	collector := &auditCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	sink := NewHTTPAuditSink(server.URL, time.Second, 100, 10*time.Millisecond)
+	defer sink.Close()
+	if err := sink.Write(&AuditRecord{UID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if collector.Count() == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Incomplete batch is not sent after the interval")
+}
+
+func TestHTTPAuditSinkDropWhenQueueIsFull(t *testing.T) {
+	collector := &auditCollector{release: make(chan struct{})}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	const batchSize = 100
+	sink := NewHTTPAuditSink(server.URL, 10*time.Second, batchSize, time.Hour)
+	accepted, dropped := 0, 0
+	// the first batch block the sender, so the queue become full
+	for i := 0; i < DefaultAuditQueueSize+2*batchSize; i++ {
+		if err := sink.Write(&AuditRecord{UID: fmt.Sprint(i)}); err != nil {
+			dropped++
+		} else {
+			accepted++
+		}
+	}
+	if dropped == 0 || accepted < DefaultAuditQueueSize {
+		t.Errorf("Expected records to be dropped only when the queue is full, accepted %d and dropped %d",
+			accepted, dropped)
+	}
+
+	close(collector.release)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if count := collector.Count(); count != accepted {
+		t.Errorf("Expected all %d accepted records to be sent on close, got %d", accepted, count)
+	}
+}
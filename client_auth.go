@@ -0,0 +1,130 @@
+package webhook_core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/devops-simba/helpers"
+)
+
+// Reasons of rejecting a client certificate
+const (
+	ClientAuthMissingCertificate   = "missing_certificate"
+	ClientAuthUntrustedCertificate = "untrusted_certificate"
+	ClientAuthCommonNameNotAllowed = "common_name_not_allowed"
+)
+
+// ClientAuthError error of authenticating a client by its certificate
+type ClientAuthError struct {
+	// Reason reason of rejection, one of ClientAuth* constants
+	Reason string
+	// CommonName common name of the client certificate, if there is one
+	CommonName string
+	Err        error
+}
+
+func (this *ClientAuthError) Error() string {
+	if this.Err != nil {
+		return fmt.Sprintf("Client certificate rejected(%s): %v", this.Reason, this.Err)
+	}
+	return fmt.Sprintf("Client certificate rejected(%s)", this.Reason)
+}
+func (this *ClientAuthError) Unwrap() error { return this.Err }
+
+// ClientAuthOptions options of authenticating the API server by its client certificate
+type ClientAuthOptions struct {
+	// ClientCAFile file that contains CA bundle that client certificates must be signed by it, empty disable mutual
+	// TLS authentication
+	ClientCAFile string
+	// AllowedCommonNames common names that client certificates must have one of them, empty means any common name
+	AllowedCommonNames []string
+}
+
+// BindToFlags bind these options to command line flags
+func (this *ClientAuthOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.StringVar(&this.ClientCAFile, "client-ca", "",
+		"Path to CA bundle that client certificates must be signed by it, if set admission requests must present a"+
+			" valid client certificate")
+	flagset.Var((*commaSeparatedList)(&this.AllowedCommonNames), "client-allowed-cns",
+		"Comma separated list of accepted common names of the client certificates, default is any common name")
+}
+
+// Enabled is mutual TLS authentication enabled
+func (this ClientAuthOptions) Enabled() bool { return this.ClientCAFile != "" }
+
+// ClientCertVerifier verify client certificate of the requests
+type ClientCertVerifier struct {
+	roots      *x509.CertPool
+	allowedCNs []string
+}
+
+// NewClientCertVerifier create a verifier from the options
+func NewClientCertVerifier(options ClientAuthOptions) (*ClientCertVerifier, error) {
+	content, err := ioutil.ReadFile(options.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("No certificate found in %s", options.ClientCAFile)
+	}
+	return &ClientCertVerifier{roots: roots, allowedCNs: options.AllowedCommonNames}, nil
+}
+
+// Verify verify client certificate of a connection, returned error is a `*ClientAuthError`
+func (this *ClientCertVerifier) Verify(state *tls.ConnectionState) error {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return &ClientAuthError{Reason: ClientAuthMissingCertificate}
+	}
+
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         this.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return &ClientAuthError{
+			Reason:     ClientAuthUntrustedCertificate,
+			CommonName: leaf.Subject.CommonName,
+			Err:        err,
+		}
+	}
+
+	if len(this.allowedCNs) != 0 && !helpers.ContainsString(this.allowedCNs, leaf.Subject.CommonName) {
+		return &ClientAuthError{Reason: ClientAuthCommonNameNotAllowed, CommonName: leaf.Subject.CommonName}
+	}
+	return nil
+}
+
+// Middleware wrap a handler so only requests with an accepted client certificate reach it, rejected requests are
+// counted, logged and replied with HTTP 401 or 403
+func (this *ClientCertVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := this.Verify(r.TLS)
+		if err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var authErr *ClientAuthError
+		errors.As(err, &authErr)
+		clientAuthRejections.WithLabelValues(authErr.Reason).Inc()
+		GetLogger().Error(err, "Rejected admission request", "path", r.URL.Path, "remoteAddr", r.RemoteAddr,
+			"commonName", authErr.CommonName)
+		if authErr.Reason == ClientAuthMissingCertificate {
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Client certificate not accepted", http.StatusForbidden)
+		}
+	})
+}
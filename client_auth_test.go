@@ -0,0 +1,166 @@
+package webhook_core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestCertificate create a certificate signed by `parent`, a self signed CA is created if `parent` is nil
+func newTestCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey crypto.Signer,
+	usages ...x509.ExtKeyUsage) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// newTestClientCertVerifier create a verifier that trust `ca` and only accept `allowedCNs`
+func newTestClientCertVerifier(t *testing.T, ca *x509.Certificate, allowedCNs ...string) *ClientCertVerifier {
+	dir, err := ioutil.TempDir("", "client-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "ca.crt")
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	if err = ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewClientCertVerifier(ClientAuthOptions{ClientCAFile: path, AllowedCommonNames: allowedCNs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+func TestClientCertVerifierMiddleware(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "trusted-ca", nil, nil)
+	untrustedCA, untrustedKey := newTestCertificate(t, "untrusted-ca", nil, nil)
+	accepted, _ := newTestCertificate(t, "kube-apiserver", ca, caKey, x509.ExtKeyUsageClientAuth)
+	untrusted, _ := newTestCertificate(t, "kube-apiserver", untrustedCA, untrustedKey, x509.ExtKeyUsageClientAuth)
+	serverOnly, _ := newTestCertificate(t, "kube-apiserver", ca, caKey, x509.ExtKeyUsageServerAuth)
+	otherCN, _ := newTestCertificate(t, "intruder", ca, caKey, x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name   string
+		state  *tls.ConnectionState
+		status int
+		reason string
+	}{
+		{name: "no TLS", status: http.StatusUnauthorized, reason: ClientAuthMissingCertificate},
+		{name: "no peer certificate", state: &tls.ConnectionState{}, status: http.StatusUnauthorized,
+			reason: ClientAuthMissingCertificate},
+		{name: "untrusted CA", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{untrusted}},
+			status: http.StatusForbidden, reason: ClientAuthUntrustedCertificate},
+		{name: "no client auth usage", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{serverOnly}},
+			status: http.StatusForbidden, reason: ClientAuthUntrustedCertificate},
+		{name: "common name not allowed", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{otherCN}},
+			status: http.StatusForbidden, reason: ClientAuthCommonNameNotAllowed},
+		{name: "accepted", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{accepted}},
+			status: http.StatusOK},
+	}
+
+	verifier := newTestClientCertVerifier(t, ca, "kube-apiserver")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			var rejections float64
+			if test.reason != "" {
+				rejections = testutil.ToFloat64(clientAuthRejections.WithLabelValues(test.reason))
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/validate", nil)
+			r.TLS = test.state
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, w.Code)
+			}
+			if called != (test.reason == "") {
+				t.Errorf("Expected handler to be called only for accepted certificates, called=%v", called)
+			}
+			if test.reason != "" {
+				delta := testutil.ToFloat64(clientAuthRejections.WithLabelValues(test.reason)) - rejections
+				if delta != 1 {
+					t.Errorf("Expected rejection with reason %s to be counted once, got %v", test.reason, delta)
+				}
+			}
+		})
+	}
+}
+
+func TestClientCertVerifierAcceptAnyCommonName(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "trusted-ca", nil, nil)
+	cert, _ := newTestCertificate(t, "anyone", ca, caKey, x509.ExtKeyUsageClientAuth)
+
+	verifier := newTestClientCertVerifier(t, ca)
+	if err := verifier.Verify(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}); err != nil {
+		t.Errorf("Expected any common name to be accepted, got %v", err)
+	}
+}
+
+func TestNewClientCertVerifierInvalidCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ca.crt")
+	if err = ioutil.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{path, filepath.Join(dir, "missing.crt")} {
+		if _, err = NewClientCertVerifier(ClientAuthOptions{ClientCAFile: file}); err == nil {
+			t.Errorf("Expected an error for %s", file)
+		}
+	}
+}
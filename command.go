@@ -4,7 +4,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/devops-simba/helpers"
 )
@@ -21,6 +24,11 @@ type CLICommand struct {
 	Port int
 	// Host host that server should listen on it
 	Host string
+	// MetricsPort port of a plain HTTP server that serve health and metrics endpoints, zero means these endpoints are
+	// served by the admission server
+	MetricsPort int
+	// MetricsHost host that the metrics server should listen on it
+	MetricsHost string
 	// Should we deploy this server as insecure? default is false
 	Insecure bool
 	// CertificateFile file that contains certificate of the server
@@ -32,6 +40,24 @@ type CLICommand struct {
 	CAFile string
 	// LogLevel level that should used for logging in the docker image
 	LogLevel int
+	// LogFormat format of the log lines, text or json
+	LogFormat LogFormat
+	// SensitiveKinds kinds of the objects that their content is redacted in the logs, in addition to Secrets
+	SensitiveKinds SensitiveKinds
+	// Tracing options of exporting traces of the admission requests
+	Tracing TracingOptions
+	// Server options of the HTTP server, like timeouts and TLS settings
+	Server ServerOptions
+	// ClientAuth options of authenticating the API server by its client certificate
+	ClientAuth ClientAuthOptions
+	// Audit options of the sinks that admission decisions are written to them
+	Audit AuditOptions
+	// Debug options of the debug server
+	Debug DebugOptions
+	// Concurrency options that limit concurrent requests of each webhook
+	Concurrency ConcurrencyOptions
+	// DecisionCache options of the cache of the decisions of validating webhooks
+	DecisionCache DecisionCacheOptions
 	// BuildProxy proxy that we should use to build go application
 	BuildProxy string
 	// ImageName name of the deployed image, default is name of the folder
@@ -58,6 +84,20 @@ type CLICommand struct {
 	ScriptFolder string
 	// Kubectl command that should used in place of kubectl
 	Kubectl string
+	// InternalErrorAction action that should be taken when a webhook fail with an error other than `PolicyError`
+	InternalErrorAction FailureAction
+	// TimeoutAction action that should be taken when a webhook fail to handle a request before its deadline
+	TimeoutAction FailureAction
+	// DeadlineMargin time that deadline of each request is shorter than timeout of its webhook
+	DeadlineMargin time.Duration
+	// MaxBodySize maximum accepted size of body of admission requests
+	MaxBodySize int64
+	// DrainPeriod time that readiness of the server fail before it stop accepting new requests on shutdown
+	DrainPeriod time.Duration
+	// ShutdownTimeout time that in-flight requests have to complete on shutdown
+	ShutdownTimeout time.Duration
+	// PreStopDelay time that preStop hook of the deployed pod wait before the server receive termination signal
+	PreStopDelay time.Duration
 
 	// ApplicationName name of this application
 	ApplicationName string
@@ -67,6 +107,33 @@ type CLICommand struct {
 	DefaultCommand string
 	// Webhooks list of webhooks that defined in this application
 	Webhooks []AdmissionWebhook
+	// Clients provider of kubernetes clients that will be passed to the webhooks
+	Clients ClientProvider
+	// Registry registry that hold webhooks and their runtime state, it is created from `Webhooks` if it is nil
+	Registry *WebhookRegistry
+	// StateConfigMap name of a ConfigMap(`name` or `namespace/name`) that contains runtime state of the webhooks
+	StateConfigMap string
+	// EnforcementModes enforcement mode of the webhooks by their name
+	EnforcementModes EnforcementModes
+	// AuditSink sink of the admission decisions, it is created from `Audit` if it is nil
+	AuditSink AuditSink
+	// Health health state of the server that is reported to the probes
+	Health *ServerHealth
+	// ConfigValues configuration values of the webhooks that passed in command line
+	ConfigValues MapConfigSource
+	// ConfigDir folder that contains configuration values of the webhooks, one file per configuration
+	ConfigDir string
+	// WatchConfigDir should `ConfigDir` be watched and changes be passed to the webhooks
+	WatchConfigDir bool
+	// ConfigPollInterval interval of checking `ConfigDir` for changes
+	ConfigPollInterval time.Duration
+	// ConfigConfigMap name of a watched ConfigMap(`name` or `namespace/name`) that contains configuration values
+	ConfigConfigMap string
+	// ConfigSecret name of a watched Secret(`name` or `namespace/name`) that contains configuration values
+	ConfigSecret string
+
+	watchedConfigSources []*WatchedConfigSource
+	decisionCache        *DecisionCache
 }
 
 func ReadCommand(
@@ -116,6 +183,10 @@ func ReadCommand(
 	if command.ImageName == "" {
 		command.ImageName = command.ApplicationName
 	}
+	if command.Clients == nil {
+		command.Clients = GetDefaultClientProvider()
+	}
+	command.ConfigureLogging()
 
 	return command
 }
@@ -133,7 +204,22 @@ func (this *CLICommand) BindToFlags(flagset *flag.FlagSet) {
 	flagset.StringVar(&this.ApplicationName, "app", this.ApplicationName, "Name of the application")
 	flagset.IntVar(&this.Port, "port", 0, "Port that server should listen on it")
 	flagset.StringVar(&this.Host, "host", "0.0.0.0", "Host that server should listen on it")
+	flagset.IntVar(&this.MetricsPort, "metrics-port", 0,
+		"Port of a plain HTTP server for health and metrics endpoints, 0 means serve them on the admission port")
+	flagset.StringVar(&this.MetricsHost, "metrics-host", "0.0.0.0", "Host that metrics server should listen on it")
 	flagset.IntVar(&this.LogLevel, "level", 0, "Level of log information")
+	this.LogFormat = LogFormatText
+	flagset.Var(&this.LogFormat, "log-format", "Format of the log lines, text(glog) or json(one object per line)")
+	flagset.Var(&this.SensitiveKinds, "redact-kinds",
+		"Kinds(version/kind or group/version/kind, * as version match all versions) that content of their objects"+
+			" and patches must be redacted in the logs and audit records, in addition to Secrets")
+	this.Tracing.BindToFlags(flagset)
+	this.Server.BindToFlags(flagset)
+	this.ClientAuth.BindToFlags(flagset)
+	this.Audit.BindToFlags(flagset)
+	this.Debug.BindToFlags(flagset)
+	this.Concurrency.BindToFlags(flagset)
+	this.DecisionCache.BindToFlags(flagset)
 	flagset.BoolVar(&this.Insecure, "insecure", false, "Should we run this server as an insecure one?")
 	flagset.StringVar(&this.CertificateFile, "cert", "",
 		"Path to file that contains certificate of the server(Used in TLS)")
@@ -160,10 +246,83 @@ func (this *CLICommand) BindToFlags(flagset *flag.FlagSet) {
 		"Folder that deployment scripts will be created in it")
 	flagset.StringVar(&this.Kubectl, "kubectl", "kubectl",
 		"Application that should used to communicate with kubenetes")
+	flagset.StringVar(&this.StateConfigMap, "state-configmap", "",
+		"Name of a ConfigMap(name or namespace/name) that contain state(enabled, disabled or an enforcement mode) of"+
+			" each webhook by its name, it is watched and changes are applied at runtime")
+	flagset.Var(&this.EnforcementModes, "enforcement-mode",
+		"Enforcement mode(enforce, warn, audit or dry-run) of webhooks as name=mode[,name=mode...], use * as name"+
+			" to set mode of all webhooks")
+	flagset.Var(&this.ConfigValues, "config",
+		"Value of a webhook configuration as NAME=VALUE, may be repeated. It override config-dir and environment")
+	flagset.StringVar(&this.ConfigDir, "config-dir", "",
+		"Folder that contains value of webhook configurations, one file per configuration(e.g. a mounted ConfigMap)")
+	flagset.BoolVar(&this.WatchConfigDir, "watch-config-dir", false,
+		"Watch config-dir for changes and pass new configurations to the webhooks that support reloading")
+	flagset.DurationVar(&this.ConfigPollInterval, "config-poll-interval", 10*time.Second,
+		"Interval of checking config-dir for changes")
+	flagset.StringVar(&this.ConfigConfigMap, "config-configmap", "",
+		"Name of a ConfigMap(name or namespace/name) that contains value of webhook configurations, it is watched and"+
+			" changes are passed to the webhooks that support reloading")
+	flagset.StringVar(&this.ConfigSecret, "config-secret", "",
+		"Name of a Secret(name or namespace/name) that contains value of webhook configurations, it is watched and"+
+			" changes are passed to the webhooks that support reloading")
+	this.InternalErrorAction = FailureActionFail
+	flagset.Var(&this.InternalErrorAction, "on-internal-error",
+		"What to do when a webhook fail with an internal error, one of deny, allow or fail(reply with HTTP 500)")
+	this.TimeoutAction = FailureActionFail
+	flagset.Var(&this.TimeoutAction, "on-timeout",
+		"What to do when a webhook fail to reply before its deadline, one of deny, allow or fail(reply with HTTP 504)")
+	flagset.DurationVar(&this.DeadlineMargin, "deadline-margin", 500*time.Millisecond,
+		"Deadline of each request will be this much shorter than timeout of its webhook")
+	flagset.Int64Var(&this.MaxBodySize, "max-body-size", DefaultMaxBodySize,
+		"Maximum accepted size of body of admission requests in bytes")
+	flagset.DurationVar(&this.DrainPeriod, "drain-period", 5*time.Second,
+		"Time that readiness of the server fail before it stop accepting new requests on shutdown")
+	flagset.DurationVar(&this.ShutdownTimeout, "shutdown-timeout", 20*time.Second,
+		"Time that in-flight requests have to complete on shutdown")
+	flagset.DurationVar(&this.PreStopDelay, "prestop-delay", 5*time.Second,
+		"Time that preStop hook of the deployed pod wait before the server receive termination signal")
 	flagset.StringVar(&this.Command, "command", this.DefaultCommand,
 		"Command that must executed in current execution. Available commands are: "+supportedCommands)
 }
 
+// ConfigureLogging set logger of the application according to `LogFormat` and kinds that must be redacted in the
+// logs, verbosity of the JSON logger is read from the `v` flag of glog
+func (this *CLICommand) ConfigureLogging() {
+	SetSensitiveKinds(this.SensitiveKinds)
+	if this.LogFormat != LogFormatJSON {
+		return
+	}
+
+	verbosity := 0
+	if v := flag.Lookup("v"); v != nil {
+		verbosity, _ = strconv.Atoi(v.Value.String())
+	}
+	SetLogger(NewJSONLogger(os.Stderr, verbosity))
+}
+
+// ConfigSource get source of configuration values of the webhooks, that is command line, config-configmap,
+// config-secret, config-dir and then environment
+func (this *CLICommand) ConfigSource() ConfigSource { return this.configSources() }
+func (this *CLICommand) configSources() ConfigSources {
+	result := ConfigSources{this.ConfigValues}
+	for _, source := range this.watchedConfigSources {
+		result = append(result, source)
+	}
+	if !this.WatchConfigDir {
+		result = append(result, DirConfigSource(this.ConfigDir))
+	}
+	return append(result, EnvConfigSource{})
+}
+
+// SplitObjectName split a `name` or `namespace/name` into its parts, `Namespace` is used if it has no namespace
+func (this *CLICommand) SplitObjectName(value string) (namespace, name string) {
+	if i := strings.IndexByte(value, '/'); i != -1 {
+		return value[:i], value[i+1:]
+	}
+	return this.Namespace, value
+}
+
 func (this *CLICommand) Execute() error {
 	if !flag.Parsed() {
 		return errors.New("You should only call this after calling flag.Parse")
@@ -2,39 +2,166 @@ package webhook_core
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/devops-simba/helpers"
-	log "github.com/golang/glog"
+	"go.opentelemetry.io/otel/attribute"
+	admissionApi "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // RunWebhooks run webhooks, listening to admission reviews and reply to them with a proper admission response
 func RunWebhooks(command *CLICommand) error {
+	if command.Tracing.ServiceName == "" {
+		command.Tracing.ServiceName = command.ApplicationName
+	}
+	shutdownTracing, err := SetupTracing(command.Tracing)
+	if err != nil {
+		return err
+	}
+
+	if command.AuditSink == nil {
+		command.AuditSink, err = command.Audit.CreateAuditSinks()
+		if err != nil {
+			return err
+		}
+	}
+	var samples *RequestSamples
+	if command.Debug.Enabled() {
+		samples = NewRequestSamples(command.Debug.MaxSamples)
+		if command.AuditSink == nil {
+			command.AuditSink = samples
+		} else {
+			command.AuditSink = AuditSinks{command.AuditSink, samples}
+		}
+	}
+
+	stopCh := make(chan struct{})
+	// releaseResources stop watchers and flush traces and audit records, it is called on every exit path
+	releaseResources := func() {
+		close(stopCh)
+		if err := shutdownTracing(context.Background()); err != nil {
+			GetLogger().Error(err, "Failed to flush traces")
+		}
+		if command.AuditSink != nil {
+			if err := command.AuditSink.Close(); err != nil {
+				GetLogger().Error(err, "Failed to close audit sink")
+			}
+		}
+	}
+
+	err = startConfigWatchers(command, stopCh)
+	if err != nil {
+		releaseResources()
+		return err
+	}
+
 	handler, err := createServerHandler(command)
 	if err != nil {
+		releaseResources()
+		return err
+	}
+
+	if command.StateConfigMap != "" {
+		namespace, name := command.SplitObjectName(command.StateConfigMap)
+		err = command.Registry.WatchConfigMap(command.Clients, namespace, name, stopCh)
+		if err != nil {
+			releaseResources()
+			return err
+		}
+	}
+
+	server, err := createHttpServer(command, handler)
+	if err != nil {
+		releaseResources()
+		return err
+	}
+	var metricsServer, debugServer *http.Server
+	if command.MetricsPort != 0 {
+		metricsServer = createMetricsServer(command)
+	}
+	if samples != nil {
+		debugServer = createDebugServer(command, samples)
+	}
+
+	// all ports are bound before we report readiness, so a port that is in use fail the startup
+	listeners, err := listenAll(server, metricsServer, debugServer)
+	if err != nil {
+		releaseResources()
 		return err
 	}
 
-	server := createHttpServer(command, handler)
-	stopped := make(chan error, 1)
+	stopped := make(chan error, len(listeners))
 	if command.CertificateFile != "" {
 		go func() {
-			log.V(5).Info("Starting https server")
-			err := server.ListenAndServeTLS(command.CertificateFile, command.PrivateKeyFile)
-			log.Infof("Server stopped: %v", err)
+			GetLogger().V(5).Info("Starting https server", "address", server.Addr)
+			err := server.ServeTLS(listeners[0], command.CertificateFile, command.PrivateKeyFile)
+			GetLogger().Info("Server stopped", "reason", err)
 			stopped <- err
 		}()
 	} else {
 		go func() {
-			log.V(5).Info("Starting http server")
-			err := server.ListenAndServe()
-			log.Infof("Server stopped: %v", err)
+			GetLogger().V(5).Info("Starting http server", "address", server.Addr)
+			err := server.Serve(listeners[0])
+			GetLogger().Info("Server stopped", "reason", err)
 			stopped <- err
 		}()
 	}
+	if metricsServer != nil {
+		go servePlainHttp("metrics", metricsServer, listeners[1], stopped)
+	}
+	if debugServer != nil {
+		go servePlainHttp("debug", debugServer, listeners[len(listeners)-1], stopped)
+	}
 
-	return helpers.WaitForApplicationTermination(func() { server.Shutdown(context.Background()) }, stopped)
+	command.Health.SetReady(true)
+	shutdown := func(drain bool) {
+		shutdownServer(command, server, drain)
+		if metricsServer != nil {
+			// metrics server is stopped last, so probes keep receiving a proper answer while we are draining
+			shutdownPlainServer(command, metricsServer)
+		}
+		if debugServer != nil {
+			shutdownPlainServer(command, debugServer)
+		}
+		// a server that stopped before its `Serve` is called does not own its listener yet
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		releaseResources()
+	}
+	err = helpers.WaitForApplicationTermination(func() { shutdown(true) }, stopped)
+	if err != nil {
+		// one of the servers stopped unexpectedly, stop the others without draining
+		shutdown(false)
+	}
+	return err
+}
+
+// listenAll bind address of all non-nil `servers`, listeners are returned in order of the servers
+func listenAll(servers ...*http.Server) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
 }
 func getWebhookPath(webhook AdmissionWebhook) (path string, err error) {
 	switch webhook.Type() {
@@ -50,37 +177,334 @@ func getWebhookPath(webhook AdmissionWebhook) (path string, err error) {
 	path += "/" + webhook.Name()
 	return
 }
-func admissionHandlerFunc(webhook AdmissionWebhook) http.HandlerFunc {
+func invokeWebhook(request *AdmissionRequest) (response *admissionApi.AdmissionResponse, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			panicErr := &PanicError{Value: value, Stack: debug.Stack()}
+			request.Logger().Error(panicErr, "Webhook panicked", "stack", string(panicErr.Stack))
+			response, err = nil, panicErr
+		}
+	}()
+
+	if handler, ok := request.Webhook.(AdmissionRequestHandler); ok {
+		response, err = handler.HandleAdmissionRequest(request)
+	} else {
+		response, err = request.Webhook.HandleAdmission(request.HTTPRequest, request.Review)
+	}
+	return request.Complete(response), err
+}
+
+type webhookResult struct {
+	response *admissionApi.AdmissionResponse
+	err      error
+}
+
+// getRequestTimeout get the time that a webhook have to handle a request, it is slightly shorter than timeout of
+// the webhook so we have a chance to reply before the API server give up on us
+func getRequestTimeout(command *CLICommand, webhook AdmissionWebhook) time.Duration {
+	timeoutInSeconds := webhook.TimeoutInSeconds()
+	if timeoutInSeconds <= 0 {
+		timeoutInSeconds = DefaultTimeoutInSeconds
+	}
+
+	timeout := time.Duration(timeoutInSeconds) * time.Second
+	if command.DeadlineMargin > 0 && command.DeadlineMargin < timeout {
+		timeout -= command.DeadlineMargin
+	}
+	return timeout
+}
+
+// invokeWebhookWithDeadline invoke the webhook with a context that will be cancelled when the client disconnect or
+// deadline of the request reached. Returned error is `context.DeadlineExceeded` or `context.Canceled` if webhook
+// failed to reply in time. `release` is called when the webhook finished, even if that is after the deadline.
+func invokeWebhookWithDeadline(
+	command *CLICommand,
+	request *AdmissionRequest,
+	release func()) (*admissionApi.AdmissionResponse, error) {
+	ctx, cancel := context.WithTimeout(request.Context(), getRequestTimeout(command, request.Webhook))
+	defer cancel()
+
+	request.HTTPRequest = request.HTTPRequest.WithContext(ctx)
+	finished := make(chan webhookResult, 1)
+	go func() {
+		if release != nil {
+			defer release()
+		}
+		response, err := invokeWebhook(request)
+		finished <- webhookResult{response: response, err: err}
+	}()
+
+	select {
+	case result := <-finished:
+		return result.response, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+func admissionHandlerFunc(
+	command *CLICommand,
+	webhook AdmissionWebhook,
+	limiter *ConcurrencyLimiter,
+	cache *DecisionCache) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if log.V(8) {
-			log.Infof("Request(%v):\n  Content-Type: %v\n  Content-Length: %v",
-				r.URL.Path, r.Header["Content-Type"], r.Header["Content-Length"])
-			if r.Body == nil {
-				log.Infof("Request body is nil!")
+		r, span := startRequestSpan(r, webhook)
+		defer span.End()
+
+		GetLogger().V(8).Info("Received request", "path", r.URL.Path,
+			"contentType", r.Header.Get("Content-Type"), "contentLength", r.ContentLength)
+
+		_, decodeSpan := getTracer().Start(r.Context(), "decode")
+		apiVersion, ar, err := ReadAdmissionReviewWithOptions(r, AdmissionReadOptions{
+			MaxBodySize:       command.MaxBodySize,
+			SupportedVersions: webhook.SupportedAdmissionVersions(),
+			Webhook:           webhook,
+		})
+		if err != nil {
+			recordSpanError(decodeSpan, err)
+			decodeSpan.End()
+			recordSpanError(span, err)
+			RequestLogger(webhook, nil).Error(err, "Error in deserializing admission request")
+			http.Error(w, err.Error(), GetHTTPStatusCode(err, http.StatusBadRequest))
+			return
+		}
+		decodeSpan.End()
+		setRequestSpanAttributes(span, ar)
+
+		var policyResponse, finalResponse *admissionApi.AdmissionResponse
+		var handleErr error
+		state := command.Registry.GetState(webhook.Name())
+		mode := command.Registry.GetEnforcementMode(webhook)
+		defer func() {
+			writeAuditRecord(command, webhook, ar, state, mode, policyResponse, finalResponse, handleErr)
+		}()
+
+		writeResponse := func(response *admissionApi.AdmissionResponse) {
+			finalResponse = response
+			setResponseSpanAttributes(span, response)
+			_, encodeSpan := getTracer().Start(r.Context(), "encode")
+			defer encodeSpan.End()
+			WriteAdmissionResponseWithSerializer(w, NegotiateResponseSerializer(r), webhook, apiVersion, ar, response)
+		}
+
+		logger := RequestLogger(webhook, ar)
+		writeDecision := func(response *admissionApi.AdmissionResponse) {
+			policyResponse = response
+			if mode == EnforcementDryRun && response != nil {
+				if response.Allowed {
+					logger.V(5).Info("[dry-run] Request allowed", "patch", redactPatch(ar, response.Patch))
+				} else {
+					logger.V(5).Info("[dry-run] Request denied", "reason", getResponseMessage(response))
+				}
 			}
+			span.SetAttributes(attribute.String("webhook.enforcement_mode", string(mode)))
+			writeResponse(mode.Apply(webhook, response))
 		}
 
-		apiVersion, ar, err := ReadAdmissionReview(r)
-		if err != nil {
-			log.Errorf("Error in deserializing admission request: %v", err)
-			http.Error(w, "Invalid content", http.StatusBadRequest)
+		if state == WebhookDisabled {
+			logger.V(10).Info("Webhook is disabled, allowing the request")
+			span.SetAttributes(attribute.Bool("webhook.disabled", true))
+			writeResponse(AllowResponse().Response())
 			return
 		}
 
-		log.V(10).Infof("Trying to handle request with %s", webhook.Name())
-		response, err := webhook.HandleAdmission(r, ar)
+		var cacheKey string
+		if cache != nil {
+			cacheKey = DecisionCacheKey(webhook, ar.Request)
+			if response, ok := cache.lookup(webhook, cacheKey); ok {
+				logger.V(10).Info("Using cached decision")
+				span.SetAttributes(attribute.Bool("webhook.cached", true))
+				writeDecision(response)
+				return
+			}
+		}
+
+		var release func()
+		if limiter != nil {
+			// time that request wait in the queue is part of its deadline
+			ctx, cancel := context.WithTimeout(r.Context(), getRequestTimeout(command, webhook))
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			var err error
+			release, err = limiter.Acquire(ctx)
+			if err != nil {
+				handleErr = err
+				recordSpanError(span, err)
+				if errors.Is(err, context.Canceled) {
+					logger.Info("Client disconnected while request was waiting for the webhook")
+					return
+				}
+				logger.Info("Webhook is overloaded, rejecting the request")
+				span.SetAttributes(attribute.Bool("webhook.overloaded", true))
+				response := command.Concurrency.OverloadAction.CreateFailureResponse(
+					webhook, metav1.StatusReasonTooManyRequests, err)
+				if response == nil {
+					w.Header().Set("Retry-After", "1")
+					http.Error(w, err.Error(), http.StatusTooManyRequests)
+					return
+				}
+				writeDecision(response)
+				return
+			}
+		}
+
+		logger.V(10).Info("Trying to handle request")
+		handlerCtx, handlerSpan := getTracer().Start(r.Context(), "handler")
+		response, err := invokeWebhookWithDeadline(command,
+			NewAdmissionRequest(r.WithContext(handlerCtx), ar, webhook, command.Clients), release)
 		if err != nil {
-			e := fmt.Sprintf("Error in handling admission request: %v", err)
-			log.Error(e)
-			http.Error(w, e, http.StatusBadRequest)
-		} else {
-			WriteAdmissionResponse(w, apiVersion, ar, response)
+			recordSpanError(handlerSpan, err)
+		}
+		handlerSpan.End()
+		handleErr = err
+		cacheable := err == nil
+		if err != nil {
+			var policyErr *PolicyError
+			if errors.Is(err, context.Canceled) {
+				logger.Info("Client disconnected before webhook handle the request")
+				recordSpanError(span, err)
+				return
+			} else if errors.Is(err, context.DeadlineExceeded) {
+				logger.Error(err, "Webhook failed to handle the request in time")
+				response = command.TimeoutAction.CreateFailureResponse(webhook, metav1.StatusReasonTimeout, err)
+				if response == nil {
+					recordSpanError(span, err)
+					http.Error(w, "Webhook timed out", http.StatusGatewayTimeout)
+					return
+				}
+			} else if errors.As(err, &policyErr) {
+				logger.V(8).Info("Request denied by policy", "reason", err)
+				response = policyErr.Response()
+				cacheable = true
+			} else {
+				e := fmt.Sprintf("Error in handling admission request: %v", err)
+				logger.Error(err, "Error in handling admission request")
+				response = command.InternalErrorAction.CreateFailureResponse(webhook, metav1.StatusReasonInternalError, err)
+				if response == nil {
+					recordSpanError(span, err)
+					http.Error(w, e, http.StatusInternalServerError)
+					return
+				}
+			}
 		}
+
+		if cache != nil && cacheable && response != nil {
+			cache.Add(cacheKey, response)
+		}
+		writeDecision(response)
 	})
 }
+
+// writeAuditRecord write a decision of a webhook to audit sink of the command
+func writeAuditRecord(
+	command *CLICommand,
+	webhook AdmissionWebhook,
+	ar *admissionApi.AdmissionReview,
+	state WebhookState,
+	mode EnforcementMode,
+	policyResponse *admissionApi.AdmissionResponse,
+	response *admissionApi.AdmissionResponse,
+	err error) {
+	if command.AuditSink == nil {
+		return
+	}
+
+	record := NewAuditRecord(webhook, ar, policyResponse, response, err)
+	record.State = state
+	if state != WebhookDisabled {
+		record.EnforcementMode = mode
+	}
+	if err := command.AuditSink.Write(record); err != nil {
+		RequestLogger(webhook, ar).Error(err, "Failed to write audit record")
+	}
+}
+
+// startConfigWatchers start watching configuration sources of the command and reload webhooks on their changes
+func startConfigWatchers(command *CLICommand, stopCh <-chan struct{}) error {
+	if command.Registry == nil {
+		command.Registry = NewWebhookRegistry(command.Webhooks...)
+	}
+
+	command.watchedConfigSources = nil
+	if command.ConfigConfigMap != "" {
+		namespace, name := command.SplitObjectName(command.ConfigConfigMap)
+		source, err := WatchConfigMapSource(command.Clients, namespace, name, stopCh)
+		if err != nil {
+			return err
+		}
+		command.watchedConfigSources = append(command.watchedConfigSources, source)
+	}
+	if command.ConfigSecret != "" {
+		namespace, name := command.SplitObjectName(command.ConfigSecret)
+		source, err := WatchSecretSource(command.Clients, namespace, name, stopCh)
+		if err != nil {
+			return err
+		}
+		command.watchedConfigSources = append(command.watchedConfigSources, source)
+	}
+	if command.WatchConfigDir && command.ConfigDir != "" {
+		source, err := WatchDirSource(command.ConfigDir, command.ConfigPollInterval, stopCh)
+		if err != nil {
+			return err
+		}
+		command.watchedConfigSources = append(command.watchedConfigSources, source)
+	}
+
+	var reloadLock sync.Mutex
+	for _, source := range command.watchedConfigSources {
+		source.OnChange(func() {
+			reloadLock.Lock()
+			defer reloadLock.Unlock()
+
+			for _, webhook := range command.Registry.Webhooks() {
+				if err := ReloadWebhook(webhook, command.ConfigSource()); err != nil {
+					GetLogger().Error(err, "Failed to reload configuration, keeping current configuration")
+				}
+			}
+			if command.decisionCache != nil {
+				// cached decisions are made by previous configuration
+				command.decisionCache.Purge()
+			}
+		})
+	}
+	return nil
+}
+
 func createServerHandler(command *CLICommand) (http.Handler, error) {
+	if command.Registry == nil {
+		command.Registry = NewWebhookRegistry(command.Webhooks...)
+	}
+	command.Registry.SetEnforcementModes(command.EnforcementModes)
+
+	if command.Health == nil {
+		command.Health = NewServerHealth()
+	}
+
+	var verifier *ClientCertVerifier
+	if command.ClientAuth.Enabled() {
+		if command.CertificateFile == "" {
+			return nil, errors.New("Client certificate authentication require a TLS server")
+		}
+		var err error
+		verifier, err = NewClientCertVerifier(command.ClientAuth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	command.decisionCache = command.DecisionCache.CreateCache()
+
 	mux := http.NewServeMux()
-	for _, webhook := range command.Webhooks {
+	if command.MetricsPort == 0 {
+		registerMetricsHandlers(command, mux)
+	}
+	for _, webhook := range command.Registry.Webhooks() {
+		if clientAware, ok := webhook.(ClientAwareWebhook); ok {
+			clientAware.SetClientProvider(command.Clients)
+		}
+		if err := ConfigureWebhook(webhook, command.ConfigSource()); err != nil {
+			return nil, err
+		}
 		webhook.Initialize()
 
 		path, err := getWebhookPath(webhook)
@@ -88,11 +512,83 @@ func createServerHandler(command *CLICommand) (http.Handler, error) {
 			return nil, err
 		}
 
-		mux.Handle(path, admissionHandlerFunc(webhook))
+		var cache *DecisionCache
+		if command.DecisionCache.Enabled(webhook) {
+			cache = command.decisionCache
+		}
+		var handler http.Handler = admissionHandlerFunc(command, webhook,
+			command.Concurrency.CreateLimiter(webhook), cache)
+		if verifier != nil {
+			handler = verifier.Middleware(handler)
+		}
+		mux.Handle(path, handler)
 	}
 	return mux, nil
 }
-func createHttpServer(command *CLICommand, handler http.Handler) *http.Server {
+
+// registerMetricsHandlers register health and metrics endpoints in `mux`
+func registerMetricsHandlers(command *CLICommand, mux *http.ServeMux) {
+	command.Health.RegisterHandlers(mux)
+	mux.Handle(MetricsPath, MetricsHandler())
+}
+
+// createMetricsServer create a plain HTTP server that serve health and metrics endpoints on `MetricsPort`
+func createMetricsServer(command *CLICommand) *http.Server {
+	mux := http.NewServeMux()
+	registerMetricsHandlers(command, mux)
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", command.MetricsHost, command.MetricsPort),
+		Handler:           mux,
+		ReadHeaderTimeout: command.Server.ReadHeaderTimeout,
+		ReadTimeout:       command.Server.ReadTimeout,
+		WriteTimeout:      command.Server.WriteTimeout,
+		IdleTimeout:       command.Server.IdleTimeout,
+	}
+}
+
+// servePlainHttp serve a plain HTTP server beside the admission server and report its stop to `stopped`
+func servePlainHttp(name string, server *http.Server, listener net.Listener, stopped chan<- error) {
+	GetLogger().V(5).Info("Starting "+name+" server", "address", server.Addr)
+	err := server.Serve(listener)
+	GetLogger().Info("Server stopped", "server", name, "reason", err)
+	stopped <- err
+}
+
+// shutdownPlainServer shutdown a plain HTTP server after the admission server is stopped
+func shutdownPlainServer(command *CLICommand, server *http.Server) {
+	ctx := context.Background()
+	if command.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+	}
+}
+
+// shutdownServer fail readiness of the server, wait for `DrainPeriod` if `drain` is true so the API server stop
+// sending new requests to us and then shutdown the server, giving in-flight requests `ShutdownTimeout` to complete
+func shutdownServer(command *CLICommand, server *http.Server, drain bool) {
+	GetLogger().Info("Shutting down the server", "drainPeriod", command.DrainPeriod,
+		"shutdownTimeout", command.ShutdownTimeout)
+	command.Health.SetReady(false)
+	if drain && command.DrainPeriod > 0 {
+		time.Sleep(command.DrainPeriod)
+	}
+
+	ctx := context.Background()
+	if command.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		GetLogger().Error(err, "In-flight requests did not complete in time, closing the server")
+		server.Close()
+	}
+}
+func createHttpServer(command *CLICommand, handler http.Handler) (*http.Server, error) {
 	port := command.Port
 	if port == 0 {
 		if command.CertificateFile != "" {
@@ -102,8 +598,17 @@ func createHttpServer(command *CLICommand, handler http.Handler) *http.Server {
 		}
 	}
 
-	return &http.Server{
+	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", command.Host, port),
 		Handler: handler,
 	}
+	if err := command.Server.Apply(server, command.CertificateFile != ""); err != nil {
+		return nil, err
+	}
+	if command.ClientAuth.Enabled() && server.TLSConfig != nil {
+		// client certificates are verified by `ClientCertVerifier` after the handshake, so rejections can be reported
+		// and the probes can be served without a client certificate
+		server.TLSConfig.ClientAuth = tls.RequestClientCert
+	}
+	return server, nil
 }
@@ -0,0 +1,179 @@
+package webhook_core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	admissionApi "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordingAuditSink an `AuditSink` that remember if it is closed
+type recordingAuditSink struct {
+	records []*AuditRecord
+	closed  bool
+}
+
+func (this *recordingAuditSink) Write(record *AuditRecord) error {
+	this.records = append(this.records, record)
+	return nil
+}
+func (this *recordingAuditSink) Close() error {
+	this.closed = true
+	return nil
+}
+
+// freePort find a port that nobody is listening on it
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestRunWebhooksReleaseResourcesWhenPortIsInUse(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	sink := &recordingAuditSink{}
+	command := &CLICommand{
+		Host:        "127.0.0.1",
+		Port:        freePort(t),
+		MetricsHost: "127.0.0.1",
+		MetricsPort: busy.Addr().(*net.TCPAddr).Port,
+		Webhooks:    []AdmissionWebhook{newTestWebhook("test-webhook", ValidatingAdmissionWebhook, nil)},
+		AuditSink:   sink,
+	}
+
+	finished := make(chan error, 1)
+	go func() { finished <- RunWebhooks(command) }()
+	select {
+	case err = <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunWebhooks did not fail when metrics port is in use")
+	}
+	if err == nil {
+		t.Fatal("Expected an error when metrics port is in use")
+	}
+	if !sink.closed {
+		t.Error("Audit sink must be closed when RunWebhooks fail")
+	}
+	if command.Health.IsReady() {
+		t.Error("Server must not be ready when a listener failed")
+	}
+
+	// admission port must be released
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", command.Port))
+	if err != nil {
+		t.Errorf("Admission port is not released: %v", err)
+	} else {
+		listener.Close()
+	}
+}
+
+func TestRunWebhooksStopOtherServersWhenOneStop(t *testing.T) {
+	sink := &recordingAuditSink{}
+	command := &CLICommand{
+		Host: "127.0.0.1",
+		Port: freePort(t),
+		// invalid certificate stop the admission server after all ports are bound
+		CertificateFile: "/nonexistent/tls.crt",
+		PrivateKeyFile:  "/nonexistent/tls.key",
+		MetricsHost:     "127.0.0.1",
+		MetricsPort:     freePort(t),
+		Webhooks:        []AdmissionWebhook{newTestWebhook("test-webhook", ValidatingAdmissionWebhook, nil)},
+		AuditSink:       sink,
+		DrainPeriod:     time.Minute,
+	}
+
+	finished := make(chan error, 1)
+	go func() { finished <- RunWebhooks(command) }()
+	var err error
+	select {
+	case err = <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunWebhooks did not return after admission server stopped")
+	}
+	if err == nil {
+		t.Fatal("Expected the error of the admission server")
+	}
+	if !sink.closed {
+		t.Error("Audit sink must be closed when a server stop unexpectedly")
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", command.MetricsPort))
+	if err != nil {
+		t.Errorf("Metrics server is not stopped: %v", err)
+	} else {
+		listener.Close()
+	}
+}
+
+func TestAdmissionHandlerTimeoutAction(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     FailureAction
+		statusCode int
+		allowed    bool
+		code       int32
+	}{
+		{name: "deny", action: FailureActionDeny, statusCode: http.StatusOK, code: http.StatusGatewayTimeout},
+		{name: "allow", action: FailureActionAllow, statusCode: http.StatusOK, allowed: true},
+		{name: "fail", action: FailureActionFail, statusCode: http.StatusGatewayTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unblock := make(chan struct{})
+			defer close(unblock)
+			deadlines := make(chan time.Time, 1)
+			webhook := newTestWebhook("slow", ValidatingAdmissionWebhook,
+				func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+					deadline, _ := request.Context().Deadline()
+					deadlines <- deadline
+					<-unblock
+					return AllowResponse().Response(), nil
+				})
+			webhook.timeout = 1
+			command := &CLICommand{
+				Registry:       NewWebhookRegistry(webhook),
+				TimeoutAction:  test.action,
+				DeadlineMargin: 900 * time.Millisecond,
+			}
+
+			started := time.Now()
+			recorder := serveAdmission(admissionHandlerFunc(command, webhook, nil, nil))
+			if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected a reply after TimeoutSeconds - DeadlineMargin, replied after %v", elapsed)
+			}
+			if deadline := <-deadlines; deadline.IsZero() || deadline.Sub(started) > 200*time.Millisecond {
+				t.Errorf("Webhook must receive a context with the request deadline, got %v", deadline)
+			}
+			if recorder.Code != test.statusCode {
+				t.Fatalf("Expected status %d, got %d: %s", test.statusCode, recorder.Code, recorder.Body.String())
+			}
+			if test.action == FailureActionFail {
+				return
+			}
+
+			var review admissionApi.AdmissionReview
+			if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+				t.Fatalf("Invalid response %s: %v", recorder.Body.String(), err)
+			}
+			if review.Response == nil || review.Response.Allowed != test.allowed {
+				t.Fatalf("Expected allowed=%v, got %s", test.allowed, recorder.Body.String())
+			}
+			if !test.allowed && (review.Response.Result == nil || review.Response.Result.Code != test.code ||
+				review.Response.Result.Reason != metav1.StatusReasonTimeout) {
+				t.Errorf("Expected a timeout denial with code %d, got %+v", test.code, review.Response.Result)
+			}
+		})
+	}
+}
@@ -7,12 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/devops-simba/helpers"
-	log "github.com/golang/glog"
 )
 
 func createScriptsFolder(command *CLICommand) (string, error) {
@@ -150,7 +150,7 @@ func loadOrCreateCert(command *CLICommand, folder string, ca *helpers.CertAndKey
 func buildTlsKeys(command *CLICommand) (string, error) {
 	if command.Insecure {
 		if command.CertificateFile != "" || command.PrivateKeyFile != "" || command.CAFile != "" {
-			log.Warning("TLS files will be ignored due to --insecure")
+			GetLogger().Info("TLS files will be ignored due to --insecure")
 		}
 		return "", nil
 	}
@@ -158,7 +158,7 @@ func buildTlsKeys(command *CLICommand) (string, error) {
 	if command.CertificateFile == "" {
 		// no explicit certificate specified, create a random self signed one
 		if command.PrivateKeyFile != "" || command.CAFile != "" {
-			log.Error("You must either provide all TLS files or none of them")
+			GetLogger().Error(nil, "You must either provide all TLS files or none of them")
 			return "", errors.New("You must only provide --key/--ca, when you also provide --cert")
 		}
 
@@ -187,7 +187,7 @@ func buildTlsKeys(command *CLICommand) (string, error) {
 		return base64.StdEncoding.EncodeToString(buf), nil
 	} else {
 		if command.PrivateKeyFile != "" {
-			log.Error("Missing private key file")
+			GetLogger().Error(nil, "Missing private key file")
 			return "", errors.New("Missing private key file")
 		}
 
@@ -208,6 +208,13 @@ func buildTlsKeys(command *CLICommand) (string, error) {
 	}
 }
 
+// getTerminationGracePeriodSeconds get grace period of the pod, that is enough for preStop hook, draining and
+// shutdown of the server
+func getTerminationGracePeriodSeconds(command *CLICommand) int {
+	gracePeriod := command.PreStopDelay + command.DrainPeriod + command.ShutdownTimeout + 5*time.Second
+	return int(math.Ceil(gracePeriod.Seconds()))
+}
+
 // CreateDeployment create deployment scripts in a folder, you may review and modify them and then
 // deploy them to the kubernetes
 func CreateDeployment(command *CLICommand) error {
@@ -248,11 +255,30 @@ func CreateDeployment(command *CLICommand) error {
 		ImageTag:      command.ImageTag,
 		ContainerPort: command.Port,
 		ServerPort:    serverPort,
+		MetricsPort:   command.MetricsPort,
 		Insecure:      command.Insecure,
 		CABundle:      caBundle,
 		TlsSecretName: command.SecretName,
 		ServiceName:   command.ServiceName,
 		ServiceUser:   command.ServiceUser,
+
+		DrainPeriod:                   command.DrainPeriod.String(),
+		ShutdownTimeout:               command.ShutdownTimeout.String(),
+		PreStopDelaySeconds:           int(math.Ceil(command.PreStopDelay.Seconds())),
+		TerminationGracePeriodSeconds: getTerminationGracePeriodSeconds(command),
+	}
+
+	if command.StateConfigMap != "" {
+		deploymentData.WatchedObjects = append(deploymentData.WatchedObjects,
+			newWatchedObjectData(command, "state-configmap", "configmaps", command.StateConfigMap))
+	}
+	if command.ConfigConfigMap != "" {
+		deploymentData.WatchedObjects = append(deploymentData.WatchedObjects,
+			newWatchedObjectData(command, "config-configmap", "configmaps", command.ConfigConfigMap))
+	}
+	if command.ConfigSecret != "" {
+		deploymentData.WatchedObjects = append(deploymentData.WatchedObjects,
+			newWatchedObjectData(command, "config-secret", "secrets", command.ConfigSecret))
 	}
 
 	for _, hook := range command.Webhooks {
@@ -301,3 +327,9 @@ func CreateDeployment(command *CLICommand) error {
 
 	return nil
 }
+
+// newWatchedObjectData create data of an object(`name` or `namespace/name`) that is passed to the server by `flag`
+func newWatchedObjectData(command *CLICommand, flag, resource, value string) WatchedObjectData {
+	namespace, name := command.SplitObjectName(value)
+	return WatchedObjectData{Flag: flag, Resource: resource, Namespace: namespace, Name: name}
+}
@@ -0,0 +1,173 @@
+package webhook_core
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ErrWebhookOverloaded webhook has too many in-flight requests and its queue is full
+	ErrWebhookOverloaded = errors.New("Webhook is overloaded")
+)
+
+var (
+	overloadRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "overload_rejections_total",
+		Help:      "Number of the requests that rejected because their webhook has too many in-flight requests",
+	}, []string{"webhook"})
+	inFlightRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "in_flight_requests",
+		Help:      "Number of the requests that are handled by webhooks that have a concurrency limit",
+	}, []string{"webhook"})
+	queuedRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "queued_requests",
+		Help:      "Number of the requests that are waiting for a webhook that reached its concurrency limit",
+	}, []string{"webhook"})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(overloadRejections, inFlightRequests, queuedRequests)
+}
+
+// ConcurrencyLimits a limit for each webhook by its name, `AllWebhooks` key set limit of all webhooks. It implement
+// `flag.Value` and parse values like `name1=10,name2=20` or `*=50`.
+type ConcurrencyLimits map[string]int
+
+// Get get limit of a webhook, zero means no limit
+func (this ConcurrencyLimits) Get(webhook AdmissionWebhook) int {
+	if limit, ok := this[webhook.Name()]; ok {
+		return limit
+	}
+	return this[AllWebhooks]
+}
+
+func (this ConcurrencyLimits) String() string {
+	items := make([]string, 0, len(this))
+	for name, limit := range this {
+		items = append(items, name+"="+strconv.Itoa(limit))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Set implement `flag.Value`
+func (this *ConcurrencyLimits) Set(value string) error {
+	if *this == nil {
+		*this = make(ConcurrencyLimits)
+	}
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid limit(%s), expected `name=limit`", item)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || limit < 0 {
+			return fmt.Errorf("Invalid limit(%s), limit must be a non-negative integer", item)
+		}
+		(*this)[strings.TrimSpace(parts[0])] = limit
+	}
+	return nil
+}
+
+// ConcurrencyOptions options that limit number of the requests that each webhook handle concurrently
+type ConcurrencyOptions struct {
+	// MaxInFlight maximum number of the requests that each webhook handle concurrently
+	MaxInFlight ConcurrencyLimits
+	// MaxQueued maximum number of the requests that wait for each webhook when it reached its `MaxInFlight`
+	MaxQueued ConcurrencyLimits
+	// OverloadAction action that should be taken when a webhook reached its limits
+	OverloadAction FailureAction
+}
+
+// BindToFlags bind these options to command line flags
+func (this *ConcurrencyOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.Var(&this.MaxInFlight, "max-in-flight",
+		"Maximum number of the requests that each webhook handle concurrently, e.g. name1=10,*=50. Default is no limit")
+	flagset.Var(&this.MaxQueued, "max-queued",
+		"Maximum number of the requests that wait for a webhook that reached its max-in-flight, e.g. name1=5,*=20")
+	this.OverloadAction = FailureActionFail
+	flagset.Var(&this.OverloadAction, "on-overload",
+		"What to do when a webhook reached its limits, one of deny, allow or fail(reply with HTTP 429)")
+}
+
+// CreateLimiter create limiter of a webhook, it return nil if webhook has no limit
+func (this ConcurrencyOptions) CreateLimiter(webhook AdmissionWebhook) *ConcurrencyLimiter {
+	maxInFlight := this.MaxInFlight.Get(webhook)
+	if maxInFlight <= 0 {
+		return nil
+	}
+	return NewConcurrencyLimiter(webhook.Name(), maxInFlight, this.MaxQueued.Get(webhook))
+}
+
+// ConcurrencyLimiter limit number of the requests that a webhook handle concurrently, requests that exceed the limit
+// wait in a bounded queue
+type ConcurrencyLimiter struct {
+	name      string
+	slots     chan struct{}
+	queued    int32
+	maxQueued int32
+}
+
+// NewConcurrencyLimiter create a limiter that allow `maxInFlight` concurrent requests and `maxQueued` waiting ones
+func NewConcurrencyLimiter(name string, maxInFlight, maxQueued int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		name:      name,
+		slots:     make(chan struct{}, maxInFlight),
+		maxQueued: int32(maxQueued),
+	}
+}
+
+// Acquire wait for a free slot, caller must call returned function when request is handled. It return
+// `ErrWebhookOverloaded` if queue is full or deadline of `ctx` reached while waiting, and `context.Canceled` if client
+// disconnected while waiting.
+func (this *ConcurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case this.slots <- struct{}{}:
+		return this.acquired(), nil
+	default:
+	}
+
+	if atomic.AddInt32(&this.queued, 1) > this.maxQueued {
+		atomic.AddInt32(&this.queued, -1)
+		overloadRejections.WithLabelValues(this.name).Inc()
+		return nil, ErrWebhookOverloaded
+	}
+	queuedRequests.WithLabelValues(this.name).Inc()
+	defer func() {
+		atomic.AddInt32(&this.queued, -1)
+		queuedRequests.WithLabelValues(this.name).Dec()
+	}()
+
+	select {
+	case this.slots <- struct{}{}:
+		return this.acquired(), nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, ctx.Err()
+		}
+		overloadRejections.WithLabelValues(this.name).Inc()
+		return nil, ErrWebhookOverloaded
+	}
+}
+
+func (this *ConcurrencyLimiter) acquired() func() {
+	inFlightRequests.WithLabelValues(this.name).Inc()
+	return func() {
+		inFlightRequests.WithLabelValues(this.name).Dec()
+		<-this.slots
+	}
+}
@@ -0,0 +1,108 @@
+package webhook_core
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	admissionApi "k8s.io/api/admission/v1"
+)
+
+func TestConcurrencyLimitsParse(t *testing.T) {
+	var options ConcurrencyOptions
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	options.BindToFlags(flagset)
+	if err := flagset.Parse([]string{"--max-in-flight", "slow=2, *=10", "--max-queued", "slow=1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := newTestWebhook("slow", ValidatingAdmissionWebhook, nil)
+	other := newTestWebhook("other", ValidatingAdmissionWebhook, nil)
+	if limit := options.MaxInFlight.Get(slow); limit != 2 {
+		t.Errorf("Expected limit 2 for slow, got %d", limit)
+	}
+	if limit := options.MaxInFlight.Get(other); limit != 10 {
+		t.Errorf("Expected wildcard limit 10 for other, got %d", limit)
+	}
+	if limit := options.MaxQueued.Get(other); limit != 0 {
+		t.Errorf("Expected no queue for other, got %d", limit)
+	}
+	if options.MaxInFlight.String() != "*=10,slow=2" {
+		t.Errorf("Unexpected string form %s", options.MaxInFlight.String())
+	}
+
+	for _, value := range []string{"slow", "slow=-1", "slow=many"} {
+		var limits ConcurrencyLimits
+		if err := limits.Set(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+// serveAdmission post an AdmissionReview to `handler` and return the recorded response
+func serveAdmission(handler http.Handler) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newAdmissionHTTPRequest(admissionReviewBody(verAdmissionApi, "AdmissionReview")))
+	return recorder
+}
+
+func TestConcurrencyLimiterHoldSlotUntilWebhookFinished(t *testing.T) {
+	unblock := make(chan struct{})
+	finished := make(chan struct{})
+	webhook := newTestWebhook("slow", ValidatingAdmissionWebhook,
+		func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+			defer close(finished)
+			<-unblock
+			return AllowResponse().Response(), nil
+		})
+	webhook.timeout = 1
+	command := &CLICommand{
+		Registry:       NewWebhookRegistry(webhook),
+		DeadlineMargin: 900 * time.Millisecond,
+	}
+	command.Concurrency.OverloadAction = FailureActionFail
+	limiter := NewConcurrencyLimiter(webhook.Name(), 1, 0)
+	handler := admissionHandlerFunc(command, webhook, limiter, nil)
+
+	// first request pass its deadline while the webhook is still running
+	serveAdmission(handler)
+	if recorder := serveAdmission(handler); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Slot of a running webhook must stay occupied, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	close(unblock)
+	<-finished
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	release, err := limiter.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Slot is not released after the webhook finished: %v", err)
+	}
+	release()
+}
+
+func TestConcurrencyLimiterDenyOverloadWithTooManyRequests(t *testing.T) {
+	webhook := newTestWebhook("busy", ValidatingAdmissionWebhook, nil)
+	command := &CLICommand{Registry: NewWebhookRegistry(webhook)}
+	command.Concurrency.OverloadAction = FailureActionDeny
+	limiter := NewConcurrencyLimiter(webhook.Name(), 1, 0)
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	recorder := serveAdmission(admissionHandlerFunc(command, webhook, limiter, nil))
+	var review admissionApi.AdmissionReview
+	if err = json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+		t.Fatalf("Invalid response %s: %v", recorder.Body.String(), err)
+	}
+	if review.Response == nil || review.Response.Allowed || review.Response.Result == nil ||
+		review.Response.Result.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a denial with code 429, got %s", recorder.Body.String())
+	}
+}
@@ -2,13 +2,25 @@ package webhook_core
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/devops-simba/helpers"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 
 	corev1 "k8s.io/api/core/v1"
@@ -17,48 +29,310 @@ import (
 
 var (
 	DefaultKubeConfigPath = os.Getenv("HOME") + "/.kube/config"
+
+	// ErrNoRESTConfig returned by client providers that are not backed by a real cluster
+	ErrNoRESTConfig = errors.New("Client provider does not have a REST configuration")
 )
 
-var initConfigOnce sync.Once
-var kubeconfig string
-var config *rest.Config
-var clientset *kubernetes.Clientset
+var defaultKubeClientOptions KubeClientOptions
+var defaultClientProviderLock sync.Mutex
+var defaultClientProvider ClientProvider
 
 func init() {
-	flag.StringVar(&kubeconfig, "kubeconfig", DefaultKubeConfigPath, "path to kubeconfig file")
+	defaultKubeClientOptions.BindToFlags(flag.CommandLine)
+}
+
+// KubeClientOptions options that control how we connect to the kubernetes
+type KubeClientOptions struct {
+	// KubeConfig path to kubeconfig file, it is only used when we are not running inside the cluster or when an
+	// explicit context is requested
+	KubeConfig string
+	// Context name of the kubeconfig context that should be used, empty means current context
+	Context string
+	// QPS maximum queries per second to the API server, zero means client-go default
+	QPS float64
+	// Burst maximum burst of queries to the API server, zero means client-go default
+	Burst int
+	// Timeout timeout of each request to the API server, zero means no timeout
+	Timeout time.Duration
 }
 
-func initializeConfig() {
-	var err error
-	config, err = rest.InClusterConfig()
+func readEnvInt(envName string, defaultValue int) int {
+	value, ok := os.LookupEnv(envName)
+	if !ok {
+		return defaultValue
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		GetLogger().Error(err, "Ignoring invalid value of environment variable", "name", envName, "value", value)
+		return defaultValue
+	}
+	return result
+}
+func readEnvFloat(envName string, defaultValue float64) float64 {
+	value, ok := os.LookupEnv(envName)
+	if !ok {
+		return defaultValue
+	}
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		GetLogger().Error(err, "Ignoring invalid value of environment variable", "name", envName, "value", value)
+		return defaultValue
+	}
+	return result
+}
+func readEnvDuration(envName string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(envName)
+	if !ok {
+		return defaultValue
+	}
+	result, err := time.ParseDuration(value)
 	if err != nil {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		GetLogger().Error(err, "Ignoring invalid value of environment variable", "name", envName, "value", value)
+		return defaultValue
+	}
+	return result
+}
+
+// BindToFlags bind these options to the flagset, default values are read from the environment
+// (KUBECONFIG, KUBE_CONTEXT, KUBE_QPS, KUBE_BURST and KUBE_TIMEOUT)
+func (this *KubeClientOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.StringVar(&this.KubeConfig, "kubeconfig", helpers.ReadEnv("KUBECONFIG", DefaultKubeConfigPath),
+		"path to kubeconfig file")
+	flagset.StringVar(&this.Context, "context", helpers.ReadEnv("KUBE_CONTEXT", ""),
+		"Name of the kubeconfig context that should be used, default is current context")
+	flagset.Float64Var(&this.QPS, "kube-qps", readEnvFloat("KUBE_QPS", 0),
+		"Maximum queries per second to the API server, 0 means client-go default")
+	flagset.IntVar(&this.Burst, "kube-burst", readEnvInt("KUBE_BURST", 0),
+		"Maximum burst of queries to the API server, 0 means client-go default")
+	flagset.DurationVar(&this.Timeout, "kube-timeout", readEnvDuration("KUBE_TIMEOUT", 0),
+		"Timeout of requests to the API server, 0 means no timeout")
+}
+
+// BuildRESTConfig build a REST configuration from these options. If no explicit context is requested in-cluster
+// configuration is preferred.
+func (this KubeClientOptions) BuildRESTConfig() (*rest.Config, error) {
+	var config *rest.Config
+	if this.Context == "" {
+		config, _ = rest.InClusterConfig()
+	}
+	if config == nil {
+		var err error
+		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: this.KubeConfig}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: this.Context}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
 		if err != nil {
-			panic(fmt.Sprintf("Error in reading kubeconfig: %v", err))
+			return nil, err
 		}
 	}
 
-	clientset, err = kubernetes.NewForConfig(config)
+	if this.QPS > 0 {
+		config.QPS = float32(this.QPS)
+	}
+	if this.Burst > 0 {
+		config.Burst = this.Burst
+	}
+	if this.Timeout > 0 {
+		config.Timeout = this.Timeout
+	}
+	config.Wrap(NewTracingRoundTripper)
+	return config, nil
+}
+
+// ClientProvider provide kubernetes clients to the webhooks
+type ClientProvider interface {
+	// GetRESTConfig get REST configuration that clients of this provider created from it
+	GetRESTConfig() (*rest.Config, error)
+	// GetClientset get typed kubernetes client
+	GetClientset() (kubernetes.Interface, error)
+	// GetDynamicClient get a client that work with unstructured objects of any resource
+	GetDynamicClient() (dynamic.Interface, error)
+	// GetDiscoveryClient get a client that discover resources that supported by the server
+	GetDiscoveryClient() (discovery.DiscoveryInterface, error)
+	// GetRESTMapper get a mapper that map kinds to resources using discovery information
+	GetRESTMapper() (meta.RESTMapper, error)
+}
+
+// kubeConfigClientProvider a `ClientProvider` that load its configuration from the cluster or a kubeconfig file
+type kubeConfigClientProvider struct {
+	options   KubeClientOptions
+	initOnce  sync.Once
+	initErr   error
+	config    *rest.Config
+	clientset *kubernetes.Clientset
+	dynamic   dynamic.Interface
+	discovery discovery.CachedDiscoveryInterface
+	mapper    meta.RESTMapper
+}
+
+// NewKubeConfigClientProvider create a `ClientProvider` that build its configuration from `options`.
+// Clients are created on first use.
+func NewKubeConfigClientProvider(options KubeClientOptions) ClientProvider {
+	return &kubeConfigClientProvider{options: options}
+}
+
+func (this *kubeConfigClientProvider) initialize() {
+	config, err := this.options.BuildRESTConfig()
+	if err != nil {
+		this.initErr = fmt.Errorf("Error in reading kubeconfig: %v", err)
+		return
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(fmt.Sprintf("Error in create kubernetes client: %v", err))
+		this.initErr = fmt.Errorf("Error in create kubernetes client: %v", err)
+		return
 	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		this.initErr = fmt.Errorf("Error in create dynamic client: %v", err)
+		return
+	}
+
+	this.config = config
+	this.clientset = clientset
+	this.dynamic = dynamicClient
+	this.discovery = memory.NewMemCacheClient(clientset.Discovery())
+	this.mapper = restmapper.NewDeferredDiscoveryRESTMapper(this.discovery)
+}
+func (this *kubeConfigClientProvider) GetRESTConfig() (*rest.Config, error) {
+	this.initOnce.Do(this.initialize)
+	return this.config, this.initErr
 }
+func (this *kubeConfigClientProvider) GetClientset() (kubernetes.Interface, error) {
+	this.initOnce.Do(this.initialize)
+	if this.initErr != nil {
+		return nil, this.initErr
+	}
+	return this.clientset, nil
+}
+func (this *kubeConfigClientProvider) GetDynamicClient() (dynamic.Interface, error) {
+	this.initOnce.Do(this.initialize)
+	if this.initErr != nil {
+		return nil, this.initErr
+	}
+	return this.dynamic, nil
+}
+func (this *kubeConfigClientProvider) GetDiscoveryClient() (discovery.DiscoveryInterface, error) {
+	this.initOnce.Do(this.initialize)
+	if this.initErr != nil {
+		return nil, this.initErr
+	}
+	return this.discovery, nil
+}
+func (this *kubeConfigClientProvider) GetRESTMapper() (meta.RESTMapper, error) {
+	this.initOnce.Do(this.initialize)
+	if this.initErr != nil {
+		return nil, this.initErr
+	}
+	return this.mapper, nil
+}
+
+// GetDefaultClientProvider get the provider that used by global helpers of this package, if no provider is set
+// it create one from `--kubeconfig`, `--context` and other client flags
+func GetDefaultClientProvider() ClientProvider {
+	defaultClientProviderLock.Lock()
+	defer defaultClientProviderLock.Unlock()
+
+	if defaultClientProvider == nil {
+		defaultClientProvider = NewKubeConfigClientProvider(defaultKubeClientOptions)
+	}
+	return defaultClientProvider
+}
+
+// SetDefaultClientProvider replace the provider that used by global helpers of this package
+func SetDefaultClientProvider(provider ClientProvider) {
+	defaultClientProviderLock.Lock()
+	defer defaultClientProviderLock.Unlock()
+
+	defaultClientProvider = provider
+}
+
+// GetRESTConfig get REST configuration of the default client provider
+func GetRESTConfig() (*rest.Config, error) {
+	return GetDefaultClientProvider().GetRESTConfig()
+}
+
+// GetClientset get Clientset object from the default client provider
+func GetClientset() (kubernetes.Interface, error) {
+	return GetDefaultClientProvider().GetClientset()
+}
+
+// GetDynamicClient get dynamic client from the default client provider
+func GetDynamicClient() (dynamic.Interface, error) {
+	return GetDefaultClientProvider().GetDynamicClient()
+}
+
+// GetDiscoveryClient get discovery client from the default client provider
+func GetDiscoveryClient() (discovery.DiscoveryInterface, error) {
+	return GetDefaultClientProvider().GetDiscoveryClient()
+}
+
+// GetRESTMapper get RESTMapper from the default client provider
+func GetRESTMapper() (meta.RESTMapper, error) {
+	return GetDefaultClientProvider().GetRESTMapper()
+}
+
+// GetResourceInterfaceFrom get a dynamic client for resource of `gvk` in `namespace` using a specific client provider,
+// namespace is ignored for cluster scoped resources
+func GetResourceInterfaceFrom(provider ClientProvider, gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, error) {
+	mapper, err := provider.GetRESTMapper()
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
 
-// GetRESTConfig get REST configuration that created from loading kubeconfig
-func GetRESTConfig() *rest.Config {
-	initConfigOnce.Do(initializeConfig)
-	return config
+	client, err := provider.GetDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return client.Resource(mapping.Resource).Namespace(namespace), nil
+	}
+	return client.Resource(mapping.Resource), nil
+}
+
+// GetObjectFrom get an arbitrary object by its kind using a specific client provider
+func GetObjectFrom(
+	provider ClientProvider,
+	gvk schema.GroupVersionKind,
+	ns string,
+	name string,
+	options metav1.GetOptions,
+	ctx context.Context) (*unstructured.Unstructured, error) {
+	resource, err := GetResourceInterfaceFrom(provider, gvk, ns)
+	if err != nil {
+		return nil, err
+	}
+	return resource.Get(ctx, name, options)
 }
 
-// GetClientset get Clientset object from global context
-func GetClientset() *kubernetes.Clientset {
-	initConfigOnce.Do(initializeConfig)
-	return clientset
+// GetObjectContext get an arbitrary object by its kind
+func GetObjectContext(gvk schema.GroupVersionKind, ns string, name string, options metav1.GetOptions, ctx context.Context) (*unstructured.Unstructured, error) {
+	return GetObjectFrom(GetDefaultClientProvider(), gvk, ns, name, options, ctx)
+}
+
+// GetObject get an arbitrary object by its kind
+func GetObject(gvk schema.GroupVersionKind, ns string, name string, options metav1.GetOptions) (*unstructured.Unstructured, error) {
+	return GetObjectContext(gvk, ns, name, options, context.Background())
+}
+
+// GetNamespaceFrom get information about namespace using a specific client provider
+func GetNamespaceFrom(provider ClientProvider, name string, options metav1.GetOptions, ctx context.Context) (*corev1.Namespace, error) {
+	clientset, err := provider.GetClientset()
+	if err != nil {
+		return nil, err
+	}
+	return clientset.CoreV1().Namespaces().Get(ctx, name, options)
 }
 
 // GetNamespaceContext get information about namespace
 func GetNamespaceContext(name string, options metav1.GetOptions, ctx context.Context) (*corev1.Namespace, error) {
-	return GetClientset().CoreV1().Namespaces().Get(ctx, name, options)
+	return GetNamespaceFrom(GetDefaultClientProvider(), name, options, ctx)
 }
 
 // GetNamespace get information about namespace
@@ -66,9 +340,18 @@ func GetNamespace(name string, options metav1.GetOptions) (*corev1.Namespace, er
 	return GetNamespaceContext(name, options, context.Background())
 }
 
+// GetPodFrom get information about a POD using a specific client provider
+func GetPodFrom(provider ClientProvider, ns string, name string, options metav1.GetOptions, ctx context.Context) (*corev1.Pod, error) {
+	clientset, err := provider.GetClientset()
+	if err != nil {
+		return nil, err
+	}
+	return clientset.CoreV1().Pods(ns).Get(ctx, name, options)
+}
+
 // GetPodContext get information about a POD
 func GetPodContext(ns string, name string, options metav1.GetOptions, ctx context.Context) (*corev1.Pod, error) {
-	return GetClientset().CoreV1().Pods(ns).Get(ctx, name, options)
+	return GetPodFrom(GetDefaultClientProvider(), ns, name, options, ctx)
 }
 
 // GetPod get information about a POD
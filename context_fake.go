@@ -0,0 +1,53 @@
+package webhook_core
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// FakeClientProvider a `ClientProvider` that is backed by in-memory fake clients, useful for testing webhooks
+type FakeClientProvider struct {
+	// Clientset fake clientset that returned by this provider, you may add reactors to it
+	Clientset *fake.Clientset
+	// Dynamic fake dynamic client that returned by this provider
+	Dynamic *dynamicfake.FakeDynamicClient
+	// RESTMapper mapper that returned by this provider, by default it know all built-in kubernetes types
+	RESTMapper meta.RESTMapper
+}
+
+// NewFakeClientProvider create a `FakeClientProvider` that is initialized with `objects`, objects are added to both
+// typed and dynamic clients
+func NewFakeClientProvider(objects ...runtime.Object) *FakeClientProvider {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	return &FakeClientProvider{
+		Clientset:  fake.NewSimpleClientset(objects...),
+		Dynamic:    dynamicfake.NewSimpleDynamicClient(scheme, objects...),
+		RESTMapper: testrestmapper.TestOnlyStaticRESTMapper(scheme),
+	}
+}
+
+func (this *FakeClientProvider) GetRESTConfig() (*rest.Config, error) {
+	return nil, ErrNoRESTConfig
+}
+func (this *FakeClientProvider) GetClientset() (kubernetes.Interface, error) {
+	return this.Clientset, nil
+}
+func (this *FakeClientProvider) GetDynamicClient() (dynamic.Interface, error) {
+	return this.Dynamic, nil
+}
+func (this *FakeClientProvider) GetDiscoveryClient() (discovery.DiscoveryInterface, error) {
+	return this.Clientset.Discovery(), nil
+}
+func (this *FakeClientProvider) GetRESTMapper() (meta.RESTMapper, error) {
+	return this.RESTMapper, nil
+}
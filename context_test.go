@@ -0,0 +1,218 @@
+package webhook_core
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestFakeClientProviderGetNamespace(t *testing.T) {
+	provider := NewFakeClientProvider(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}},
+	})
+
+	namespace, err := GetNamespaceFrom(provider, "team-a", metav1.GetOptions{}, context.Background())
+	if err != nil {
+		t.Fatalf("GetNamespaceFrom failed: %v", err)
+	}
+	if namespace.Labels["team"] != "a" {
+		t.Errorf("Unexpected labels of the namespace: %v", namespace.Labels)
+	}
+
+	_, err = GetNamespaceFrom(provider, "team-b", metav1.GetOptions{}, context.Background())
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected NotFound error for a missing namespace, got %v", err)
+	}
+}
+
+func TestFakeClientProviderGetObject(t *testing.T) {
+	provider := NewFakeClientProvider(
+		&corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "settings"},
+			Data:       map[string]string{"key": "value"},
+		},
+		&corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+		},
+	)
+
+	tests := []struct {
+		name      string
+		gvk       schema.GroupVersionKind
+		namespace string
+		object    string
+		notFound  bool
+	}{
+		{name: "namespaced", gvk: corev1.SchemeGroupVersion.WithKind("ConfigMap"), namespace: "team-a", object: "settings"},
+		{name: "other namespace", gvk: corev1.SchemeGroupVersion.WithKind("ConfigMap"), namespace: "team-b",
+			object: "settings", notFound: true},
+		// namespace of cluster scoped resources is ignored
+		{name: "cluster scoped", gvk: corev1.SchemeGroupVersion.WithKind("Namespace"), namespace: "ignored",
+			object: "team-a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			object, err := GetObjectFrom(provider, test.gvk, test.namespace, test.object, metav1.GetOptions{},
+				context.Background())
+			if test.notFound {
+				if !errors.IsNotFound(err) {
+					t.Fatalf("Expected NotFound error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetObjectFrom failed: %v", err)
+			}
+			if object.GetName() != test.object || object.GetKind() != test.gvk.Kind {
+				t.Errorf("Unexpected object %s/%s", object.GetKind(), object.GetName())
+			}
+		})
+	}
+
+	_, err := GetObjectFrom(provider, schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Unknown"},
+		"team-a", "settings", metav1.GetOptions{}, context.Background())
+	if err == nil {
+		t.Error("Expected an error for a kind that RESTMapper does not know")
+	}
+}
+
+// setEnv set environment variables for the duration of a test
+func setEnv(t *testing.T, values map[string]string) {
+	for name, value := range values {
+		old, existed := os.LookupEnv(name)
+		os.Setenv(name, value)
+		name := name
+		t.Cleanup(func() {
+			if existed {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestKubeClientOptionsBindToFlags(t *testing.T) {
+	setEnv(t, map[string]string{
+		"KUBECONFIG":   "/tmp/kubeconfig",
+		"KUBE_CONTEXT": "staging",
+		"KUBE_QPS":     "12.5",
+		"KUBE_BURST":   "not-a-number",
+		"KUBE_TIMEOUT": "3s",
+	})
+
+	var options KubeClientOptions
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	options.BindToFlags(flagset)
+	if err := flagset.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := KubeClientOptions{
+		KubeConfig: "/tmp/kubeconfig",
+		Context:    "staging",
+		QPS:        12.5,
+		Burst:      0, // invalid values of the environment are ignored
+		Timeout:    3 * time.Second,
+	}
+	if options != expected {
+		t.Errorf("Defaults are not read from the environment, expected %+v got %+v", expected, options)
+	}
+
+	if err := flagset.Parse([]string{"--kube-burst", "7", "--context", "production"}); err != nil {
+		t.Fatal(err)
+	}
+	if options.Burst != 7 || options.Context != "production" {
+		t.Errorf("Flags must override the environment, got %+v", options)
+	}
+}
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com
+- name: production
+  cluster:
+    server: https://production.example.com
+users:
+- name: user
+  user:
+    token: secret
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: user
+- name: production
+  context:
+    cluster: production
+    user: user
+current-context: staging
+`
+
+func TestKubeClientOptionsBuildRESTConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	if err = ioutil.WriteFile(path, []byte(testKubeConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options KubeClientOptions
+		host    string
+		qps     float32
+		burst   int
+		timeout time.Duration
+	}{
+		{
+			name:    "client-go defaults",
+			options: KubeClientOptions{KubeConfig: path, Context: "staging"},
+			host:    "https://staging.example.com",
+		},
+		{
+			name: "explicit context and limits",
+			options: KubeClientOptions{KubeConfig: path, Context: "production", QPS: 20, Burst: 40,
+				Timeout: 5 * time.Second},
+			host:    "https://production.example.com",
+			qps:     20,
+			burst:   40,
+			timeout: 5 * time.Second,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.options.BuildRESTConfig()
+			if err != nil {
+				t.Fatalf("BuildRESTConfig failed: %v", err)
+			}
+			if config.Host != test.host {
+				t.Errorf("Expected host %s, got %s", test.host, config.Host)
+			}
+			if config.QPS != test.qps || config.Burst != test.burst || config.Timeout != test.timeout {
+				t.Errorf("Unexpected limits qps=%v burst=%v timeout=%v", config.QPS, config.Burst, config.Timeout)
+			}
+		})
+	}
+
+	_, err = KubeClientOptions{KubeConfig: path, Context: "missing"}.BuildRESTConfig()
+	if err == nil {
+		t.Error("Expected an error for a missing context")
+	}
+}
@@ -0,0 +1,201 @@
+package webhook_core
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+
+	admissionRegistration "k8s.io/api/admissionregistration/v1"
+)
+
+const (
+	// DebugPprofPath path of the pprof endpoints on the debug server
+	DebugPprofPath = "/debug/pprof/"
+	// DebugWebhooksPath path of the dump of the registered webhooks on the debug server
+	DebugWebhooksPath = "/debug/webhooks"
+	// DebugRequestsPath path of the recent request samples on the debug server
+	DebugRequestsPath = "/debug/requests"
+)
+
+// DebugOptions options of the debug server that expose pprof, registered webhooks and recent requests
+type DebugOptions struct {
+	// Port port of the debug server, zero means debug server is disabled
+	Port int
+	// Host host that the debug server should listen on it
+	Host string
+	// MaxSamples number of the recent requests that are kept for the debug server
+	MaxSamples int
+}
+
+// BindToFlags bind these options to command line flags
+func (this *DebugOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.IntVar(&this.Port, "debug-port", 0,
+		"Port of a plain HTTP server for pprof, registered webhooks and recent requests, 0 means disabled")
+	flagset.StringVar(&this.Host, "debug-host", "127.0.0.1", "Host that debug server should listen on it")
+	flagset.IntVar(&this.MaxSamples, "debug-samples", 50, "Number of the recent requests that debug server keep")
+}
+
+// Enabled is debug server enabled
+func (this DebugOptions) Enabled() bool { return this.Port != 0 }
+
+// RequestSamples an `AuditSink` that keep the most recent records in memory
+type RequestSamples struct {
+	lock    sync.Mutex
+	records []*AuditRecord
+	next    int
+	full    bool
+}
+
+// NewRequestSamples create a sink that keep `capacity` most recent records
+func NewRequestSamples(capacity int) *RequestSamples {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &RequestSamples{records: make([]*AuditRecord, capacity)}
+}
+
+func (this *RequestSamples) Write(record *AuditRecord) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.records[this.next] = record
+	this.next = (this.next + 1) % len(this.records)
+	if this.next == 0 {
+		this.full = true
+	}
+	return nil
+}
+func (this *RequestSamples) Close() error { return nil }
+
+// Samples get kept records, newest first
+func (this *RequestSamples) Samples() []*AuditRecord {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	count := this.next
+	if this.full {
+		count = len(this.records)
+	}
+	result := make([]*AuditRecord, 0, count)
+	for i := 1; i <= count; i++ {
+		result = append(result, this.records[(this.next-i+len(this.records))%len(this.records)])
+	}
+	return result
+}
+
+// debugConfigValue state of a configuration of a webhook in the dump of the debug server, values of sensitive
+// configurations and values that are loaded from a Secret are redacted
+type debugConfigValue struct {
+	Name     string     `json:"name"`
+	Type     ConfigType `json:"type,omitempty"`
+	Required bool       `json:"required,omitempty"`
+	Found    bool       `json:"found"`
+	Value    string     `json:"value,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// getDebugConfigValue get value of a configuration as it is dumped by the debug server
+func getDebugConfigValue(config WebhookConfiguration, sources ConfigSources) string {
+	value, found, sensitive := "", false, config.Sensitive
+	for _, source := range sources {
+		if value, found = source.LookupConfig(config.Name); found {
+			if watched, ok := source.(*WatchedConfigSource); ok && watched.Sensitive() {
+				sensitive = true
+			}
+			break
+		}
+	}
+	if !found && config.DefaultValue != nil {
+		value = *config.DefaultValue
+	}
+	if sensitive && value != "" {
+		return RedactedValue
+	}
+	return value
+}
+
+// debugWebhook a registered webhook in the dump of the debug server
+type debugWebhook struct {
+	Name                       string                                     `json:"name"`
+	Type                       AdmissionWebhookType                       `json:"type"`
+	Path                       string                                     `json:"path"`
+	State                      WebhookState                               `json:"state"`
+	EnforcementMode            EnforcementMode                            `json:"enforcementMode"`
+	TimeoutInSeconds           int                                        `json:"timeoutInSeconds"`
+	SideEffects                admissionRegistration.SideEffectClass      `json:"sideEffects,omitempty"`
+	SupportedAdmissionVersions []string                                   `json:"supportedAdmissionVersions"`
+	Rules                      []admissionRegistration.RuleWithOperations `json:"rules"`
+	Configurations             []debugConfigValue                         `json:"configurations"`
+}
+
+// dumpWebhooks get registered webhooks of the command with state of their configurations
+func dumpWebhooks(command *CLICommand) []debugWebhook {
+	sources := command.configSources()
+	webhooks := command.Registry.Webhooks()
+	result := make([]debugWebhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		path, _ := getWebhookPath(webhook)
+		item := debugWebhook{
+			Name:                       webhook.Name(),
+			Type:                       webhook.Type(),
+			Path:                       path,
+			State:                      command.Registry.GetState(webhook.Name()),
+			EnforcementMode:            command.Registry.GetEnforcementMode(webhook),
+			TimeoutInSeconds:           webhook.TimeoutInSeconds(),
+			SideEffects:                webhook.SideEffects(),
+			SupportedAdmissionVersions: webhook.SupportedAdmissionVersions(),
+			Rules:                      webhook.Rules(),
+		}
+		for _, config := range webhook.Configurations() {
+			value := debugConfigValue{Name: config.Name, Type: config.Type, Required: config.Required}
+			var err error
+			_, value.Found, err = config.Load(sources)
+			if err != nil {
+				value.Error = err.Error()
+			}
+			value.Value = getDebugConfigValue(config, sources)
+			item.Configurations = append(item.Configurations, value)
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// writeDebugJSON write `value` as an indented JSON response
+func writeDebugJSON(w http.ResponseWriter, value interface{}) {
+	body, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to serialize response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// createDebugServer create a plain HTTP server that serve pprof, dump of the registered webhooks and `samples` on
+// port of the debug options
+func createDebugServer(command *CLICommand, samples *RequestSamples) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DebugPprofPath, pprof.Index)
+	mux.HandleFunc(DebugPprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(DebugPprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(DebugPprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(DebugPprofPath+"trace", pprof.Trace)
+	mux.HandleFunc(DebugWebhooksPath, func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, dumpWebhooks(command))
+	})
+	mux.HandleFunc(DebugRequestsPath, func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, samples.Samples())
+	})
+
+	// no write timeout, CPU profiles and traces take as long as the client asked
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", command.Debug.Host, command.Debug.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
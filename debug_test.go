@@ -0,0 +1,88 @@
+package webhook_core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequestSamplesKeepNewestFirst(t *testing.T) {
+	samples := NewRequestSamples(3)
+	for _, uid := range []string{"1", "2", "3", "4", "5"} {
+		samples.Write(&AuditRecord{UID: uid})
+	}
+
+	records := samples.Samples()
+	uids := make([]string, 0, len(records))
+	for _, record := range records {
+		uids = append(uids, record.UID)
+	}
+	if strings.Join(uids, ",") != "5,4,3" {
+		t.Errorf("Expected 5,4,3 got %s", strings.Join(uids, ","))
+	}
+}
+
+func TestDebugServerRedactSensitiveConfigValues(t *testing.T) {
+	webhook := newTestWebhook("secrets", ValidatingAdmissionWebhook, nil)
+	webhook.configurations = []WebhookConfiguration{
+		{Name: "TEST_DEBUG_OWNER", Type: ConfigString},
+		{Name: "TEST_DEBUG_TOKEN", Type: ConfigString, Required: true, Sensitive: true},
+		{Name: "TEST_DEBUG_PASSWORD", Type: ConfigString},
+		CreateTypedConfig("TEST_DEBUG_LEVEL", ConfigString, "info", ""),
+		{Name: "TEST_DEBUG_MISSING", Type: ConfigString, Required: true},
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	secretSource, err := WatchSecretSource(NewFakeClientProvider(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "webhooks", Name: "config"},
+		Data:       map[string][]byte{"TEST_DEBUG_PASSWORD": []byte("from-secret")},
+	}), "webhooks", "config", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := &CLICommand{
+		Registry:             NewWebhookRegistry(webhook),
+		ConfigValues:         MapConfigSource{"TEST_DEBUG_OWNER": "team-a", "TEST_DEBUG_TOKEN": "top-secret"},
+		watchedConfigSources: []*WatchedConfigSource{secretSource},
+	}
+
+	recorder := httptest.NewRecorder()
+	createDebugServer(command, NewRequestSamples(1)).Handler.ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, DebugWebhooksPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	for _, secret := range []string{"top-secret", "from-secret"} {
+		if strings.Contains(recorder.Body.String(), secret) {
+			t.Fatalf("Sensitive values must not be dumped: %s", recorder.Body.String())
+		}
+	}
+
+	var webhooks []debugWebhook
+	if err := json.Unmarshal(recorder.Body.Bytes(), &webhooks); err != nil {
+		t.Fatalf("Invalid dump %s: %v", recorder.Body.String(), err)
+	}
+	if len(webhooks) != 1 || len(webhooks[0].Configurations) != 5 {
+		t.Fatalf("Unexpected dump: %+v", webhooks)
+	}
+	expected := map[string]string{
+		"TEST_DEBUG_OWNER":    "team-a",
+		"TEST_DEBUG_TOKEN":    RedactedValue,
+		"TEST_DEBUG_PASSWORD": RedactedValue,
+		"TEST_DEBUG_LEVEL":    "info",
+		"TEST_DEBUG_MISSING":  "",
+	}
+	for _, config := range webhooks[0].Configurations {
+		if config.Value != expected[config.Name] {
+			t.Errorf("Expected value %q for %s, got %q", expected[config.Name], config.Name, config.Value)
+		}
+		if missing := config.Name == "TEST_DEBUG_MISSING"; missing != (config.Error != "") {
+			t.Errorf("Only missing required configuration must be reported: %+v", config)
+		}
+	}
+}
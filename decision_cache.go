@@ -0,0 +1,184 @@
+package webhook_core
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionApi "k8s.io/api/admission/v1"
+	authenticationApi "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	decisionCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "decision_cache_hits_total",
+		Help:      "Number of the requests that answered from the decision cache",
+	}, []string{"webhook"})
+	decisionCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "decision_cache_misses_total",
+		Help:      "Number of the requests that their decision was not in the decision cache",
+	}, []string{"webhook"})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(decisionCacheHits, decisionCacheMisses)
+}
+
+// DecisionCacheOptions options of the cache of the decisions of validating webhooks
+type DecisionCacheOptions struct {
+	// Webhooks name of the validating webhooks that their decisions are cached, `*` means all validating webhooks
+	Webhooks []string
+	// Size maximum number of the cached decisions
+	Size int
+	// TTL time that a decision is kept in the cache
+	TTL time.Duration
+}
+
+// BindToFlags bind these options to command line flags
+func (this *DecisionCacheOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.Var((*commaSeparatedList)(&this.Webhooks), "decision-cache",
+		"Comma separated list of validating webhooks that their decisions are cached, * means all of them")
+	flagset.IntVar(&this.Size, "decision-cache-size", 1000, "Maximum number of the cached decisions")
+	flagset.DurationVar(&this.TTL, "decision-cache-ttl", 10*time.Second,
+		"Time that a decision is kept in the cache")
+}
+
+// Enabled should decisions of `webhook` be cached
+func (this DecisionCacheOptions) Enabled(webhook AdmissionWebhook) bool {
+	if webhook.Type() != ValidatingAdmissionWebhook {
+		return false
+	}
+	for _, name := range this.Webhooks {
+		if name == AllWebhooks || name == webhook.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateCache create a cache for these options, it return nil if no webhook is cached
+func (this DecisionCacheOptions) CreateCache() *DecisionCache {
+	if len(this.Webhooks) == 0 || this.Size <= 0 || this.TTL <= 0 {
+		return nil
+	}
+	return NewDecisionCache(this.Size, this.TTL)
+}
+
+// DecisionCacheKey key of the decision of `webhook` for a request, requests that have same object, old object,
+// operation and user have same key
+func DecisionCacheKey(webhook AdmissionWebhook, request *admissionApi.AdmissionRequest) string {
+	hash := sha256.New()
+	json.NewEncoder(hash).Encode(struct {
+		Webhook     string
+		Operation   admissionApi.Operation
+		Resource    string
+		SubResource string
+		Namespace   string
+		Name        string
+		UserInfo    authenticationApi.UserInfo
+		Object      runtime.RawExtension
+		OldObject   runtime.RawExtension
+	}{
+		Webhook:     webhook.Name(),
+		Operation:   request.Operation,
+		Resource:    request.Resource.String(),
+		SubResource: request.SubResource,
+		Namespace:   request.Namespace,
+		Name:        request.Name,
+		UserInfo:    request.UserInfo,
+		Object:      request.Object,
+		OldObject:   request.OldObject,
+	})
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type decisionCacheEntry struct {
+	key      string
+	response *admissionApi.AdmissionResponse
+	expires  time.Time
+}
+
+// DecisionCache an LRU cache of the admission responses that expire after a TTL
+type DecisionCache struct {
+	lock    sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewDecisionCache create a cache that keep `size` most recently used responses for `ttl`
+func NewDecisionCache(size int, ttl time.Duration) *DecisionCache {
+	return &DecisionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get get a copy of the cached response of `key`
+func (this *DecisionCache) Get(key string) (*admissionApi.AdmissionResponse, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	element, ok := this.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*decisionCacheEntry)
+	if time.Now().After(entry.expires) {
+		this.order.Remove(element)
+		delete(this.entries, key)
+		return nil, false
+	}
+	this.order.MoveToFront(element)
+	return entry.response.DeepCopy(), true
+}
+
+// Add add a copy of `response` to the cache, least recently used response is removed if cache is full
+func (this *DecisionCache) Add(key string, response *admissionApi.AdmissionResponse) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	entry := &decisionCacheEntry{key: key, response: response.DeepCopy(), expires: time.Now().Add(this.ttl)}
+	if element, ok := this.entries[key]; ok {
+		element.Value = entry
+		this.order.MoveToFront(element)
+		return
+	}
+	this.entries[key] = this.order.PushFront(entry)
+	for this.order.Len() > this.size {
+		oldest := this.order.Back()
+		this.order.Remove(oldest)
+		delete(this.entries, oldest.Value.(*decisionCacheEntry).key)
+	}
+}
+
+// Purge remove all cached responses
+func (this *DecisionCache) Purge() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.entries = make(map[string]*list.Element)
+	this.order.Init()
+}
+
+// lookup get cached response of `webhook` and count the hit or miss
+func (this *DecisionCache) lookup(webhook AdmissionWebhook, key string) (*admissionApi.AdmissionResponse, bool) {
+	response, ok := this.Get(key)
+	if ok {
+		decisionCacheHits.WithLabelValues(webhook.Name()).Inc()
+	} else {
+		decisionCacheMisses.WithLabelValues(webhook.Name()).Inc()
+	}
+	return response, ok
+}
@@ -0,0 +1,122 @@
+package webhook_core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionApi "k8s.io/api/admission/v1"
+)
+
+func TestDecisionCacheEvictLeastRecentlyUsed(t *testing.T) {
+	cache := NewDecisionCache(2, time.Minute)
+	cache.Add("a", AllowResponse().Response())
+	cache.Add("b", AllowResponse().Response())
+	// `a` is used after `b`, so `b` is the least recently used one
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("Expected `a` in the cache")
+	}
+	cache.Add("c", AllowResponse().Response())
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.Get(key); ok != expected {
+			t.Errorf("Expected `%s` in cache to be %v", key, expected)
+		}
+	}
+}
+
+func TestDecisionCacheExpire(t *testing.T) {
+	cache := NewDecisionCache(10, 20*time.Millisecond)
+	cache.Add("a", DenyResponse("not allowed").Response())
+	if response, ok := cache.Get("a"); !ok || response.Allowed {
+		t.Fatalf("Expected cached denial, got %+v", response)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expired decisions must not be returned")
+	}
+}
+
+func TestDecisionCachePurgeOnConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command := &CLICommand{
+		Registry:           NewWebhookRegistry(newTestWebhook("cached", ValidatingAdmissionWebhook, nil)),
+		ConfigDir:          dir,
+		WatchConfigDir:     true,
+		ConfigPollInterval: 10 * time.Millisecond,
+		decisionCache:      NewDecisionCache(10, time.Minute),
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err = startConfigWatchers(command, stopCh); err != nil {
+		t.Fatal(err)
+	}
+
+	command.decisionCache.Add("a", AllowResponse().Response())
+	if err = ioutil.WriteFile(filepath.Join(dir, "TEST_CACHE_CONFIG"), []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if _, ok := command.decisionCache.Get("a"); !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Decision cache is not purged after configuration changed")
+}
+
+func TestDecisionCacheHandlerCountHitsAndMisses(t *testing.T) {
+	calls := 0
+	webhook := newTestWebhook("cached-counters", ValidatingAdmissionWebhook,
+		func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+			calls++
+			return DenyResponse("not allowed").Response(), nil
+		})
+	command := &CLICommand{
+		Registry:      NewWebhookRegistry(webhook),
+		DecisionCache: DecisionCacheOptions{Webhooks: []string{AllWebhooks}, Size: 10, TTL: time.Minute},
+	}
+	if !command.DecisionCache.Enabled(webhook) {
+		t.Fatal("Expected cache to be enabled for all validating webhooks")
+	}
+	handler := admissionHandlerFunc(command, webhook, nil, command.DecisionCache.CreateCache())
+	hits, misses := decisionCacheHits.WithLabelValues(webhook.Name()), decisionCacheMisses.WithLabelValues(webhook.Name())
+	hitsBefore, missesBefore := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+
+	for i := 0; i < 3; i++ {
+		serveAdmission(handler)
+	}
+	if calls != 1 {
+		t.Errorf("Expected webhook to be called once, called %d times", calls)
+	}
+	if count := testutil.ToFloat64(hits) - hitsBefore; count != 2 {
+		t.Errorf("Expected 2 hits, got %v", count)
+	}
+	if count := testutil.ToFloat64(misses) - missesBefore; count != 1 {
+		t.Errorf("Expected 1 miss, got %v", count)
+	}
+}
+
+func TestDecisionCacheOptionsEnabled(t *testing.T) {
+	validating := newTestWebhook("validating", ValidatingAdmissionWebhook, nil)
+	mutating := newTestWebhook("mutating", MutatingAdmissionWebhook, nil)
+
+	if (DecisionCacheOptions{Webhooks: []string{AllWebhooks}}).Enabled(mutating) {
+		t.Error("Decisions of mutating webhooks must not be cached")
+	}
+	if !(DecisionCacheOptions{Webhooks: []string{"validating"}}).Enabled(validating) {
+		t.Error("Expected cache to be enabled by name")
+	}
+	if (DecisionCacheOptions{Webhooks: []string{"other"}}).Enabled(validating) {
+		t.Error("Expected cache to be disabled for other webhooks")
+	}
+}
@@ -0,0 +1,133 @@
+package webhook_core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	admissionApi "k8s.io/api/admission/v1"
+)
+
+// EnforcementMode control what happen to denials of a webhook
+type EnforcementMode string
+
+const (
+	// EnforcementEnforce denials of the webhook reject the request
+	EnforcementEnforce EnforcementMode = "enforce"
+	// EnforcementWarn denials of the webhook are converted to warnings and audit annotations
+	EnforcementWarn EnforcementMode = "warn"
+	// EnforcementAudit denials of the webhook are only recorded as audit annotations
+	EnforcementAudit EnforcementMode = "audit"
+	// EnforcementDryRun webhook is evaluated and its decision is logged, but the request is always allowed unchanged
+	EnforcementDryRun EnforcementMode = "dry-run"
+)
+
+// AllWebhooks name that match all webhooks in the options that are keyed by name of the webhooks
+const AllWebhooks = "*"
+
+// EnforcedWebhook webhooks that implement this interface specify their own default enforcement mode
+type EnforcedWebhook interface {
+	// EnforcementMode default enforcement mode of this webhook
+	EnforcementMode() EnforcementMode
+}
+
+// ParseEnforcementMode parse an enforcement mode
+func ParseEnforcementMode(value string) (EnforcementMode, error) {
+	switch mode := EnforcementMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case EnforcementEnforce, EnforcementWarn, EnforcementAudit, EnforcementDryRun:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("Invalid enforcement mode(%s), valid modes are enforce, warn, audit and dry-run", value)
+	}
+}
+
+// GetDefaultEnforcementMode get enforcement mode that webhook declare for itself, or `EnforcementEnforce`
+func GetDefaultEnforcementMode(webhook AdmissionWebhook) EnforcementMode {
+	if enforced, ok := webhook.(EnforcedWebhook); ok {
+		if mode := enforced.EnforcementMode(); mode != "" {
+			return mode
+		}
+	}
+	return EnforcementEnforce
+}
+
+// Apply apply this mode to response of `webhook`
+func (this EnforcementMode) Apply(webhook AdmissionWebhook, response *admissionApi.AdmissionResponse) *admissionApi.AdmissionResponse {
+	if response == nil || this == EnforcementEnforce || this == "" {
+		return response
+	}
+
+	if this == EnforcementDryRun {
+		return AllowResponse().Response()
+	}
+
+	if response.Allowed {
+		return response
+	}
+
+	message := getResponseMessage(response)
+	builder := AllowResponse().
+		WithWarnings(response.Warnings...).
+		WithAuditAnnotation("denied", message).
+		WithAuditAnnotation("enforcement-mode", string(this))
+	for key, value := range response.AuditAnnotations {
+		builder.WithAuditAnnotation(key, value)
+	}
+	if this == EnforcementWarn {
+		builder.WithWarnings(fmt.Sprintf("[%s] %s", webhook.Name(), message))
+	}
+	return builder.Response()
+}
+
+func getResponseMessage(response *admissionApi.AdmissionResponse) string {
+	if response.Result != nil && response.Result.Message != "" {
+		return response.Result.Message
+	}
+	return "Request denied"
+}
+
+// EnforcementModes enforcement mode of webhooks by their name, `AllWebhooks` key set mode of all webhooks.
+// It implement `flag.Value` and parse values like `name1=warn,name2=audit` or `*=dry-run`.
+type EnforcementModes map[string]EnforcementMode
+
+// Get get enforcement mode of a webhook
+func (this EnforcementModes) Get(webhook AdmissionWebhook) EnforcementMode {
+	if mode, ok := this[webhook.Name()]; ok {
+		return mode
+	}
+	if mode, ok := this[AllWebhooks]; ok {
+		return mode
+	}
+	return GetDefaultEnforcementMode(webhook)
+}
+
+func (this EnforcementModes) String() string {
+	items := make([]string, 0, len(this))
+	for name, mode := range this {
+		items = append(items, name+"="+string(mode))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Set implement `flag.Value`
+func (this *EnforcementModes) Set(value string) error {
+	if *this == nil {
+		*this = make(EnforcementModes)
+	}
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid enforcement mode(%s), expected `name=mode`", item)
+		}
+		mode, err := ParseEnforcementMode(parts[1])
+		if err != nil {
+			return err
+		}
+		(*this)[strings.TrimSpace(parts[0])] = mode
+	}
+	return nil
+}
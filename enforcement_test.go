@@ -0,0 +1,160 @@
+package webhook_core
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	admissionApi "k8s.io/api/admission/v1"
+)
+
+// enforcedTestWebhook a webhook that declare its own default enforcement mode
+type enforcedTestWebhook struct {
+	*testWebhook
+	mode EnforcementMode
+}
+
+func (this enforcedTestWebhook) EnforcementMode() EnforcementMode { return this.mode }
+
+func TestEnforcementModeApply(t *testing.T) {
+	webhook := newTestWebhook("policy", ValidatingAdmissionWebhook, nil)
+	allowed := func() *admissionApi.AdmissionResponse {
+		return AllowResponse().WithWarnings("deprecated").Response()
+	}
+	denied := func() *admissionApi.AdmissionResponse {
+		return DenyResponse("not allowed").WithAuditAnnotation("rule", "r1").Response()
+	}
+	patched := func() *admissionApi.AdmissionResponse {
+		response, _ := CreatePatchResponse([]PatchOperation{NewAddPatch("/metadata/labels", map[string]string{})})
+		return response
+	}
+
+	tests := []struct {
+		name        string
+		mode        EnforcementMode
+		response    *admissionApi.AdmissionResponse
+		allowed     bool
+		patched     bool
+		warnings    []string
+		annotations map[string]string
+	}{
+		{name: "enforce allowed", mode: EnforcementEnforce, response: allowed(), allowed: true,
+			warnings: []string{"deprecated"}},
+		{name: "enforce denied", mode: EnforcementEnforce, response: denied(),
+			annotations: map[string]string{"rule": "r1"}},
+		{name: "enforce patched", mode: EnforcementEnforce, response: patched(), allowed: true, patched: true},
+		{name: "warn allowed", mode: EnforcementWarn, response: allowed(), allowed: true,
+			warnings: []string{"deprecated"}},
+		{name: "warn denied", mode: EnforcementWarn, response: denied(), allowed: true,
+			warnings:    []string{"[policy] not allowed"},
+			annotations: map[string]string{"rule": "r1", "denied": "not allowed", "enforcement-mode": "warn"}},
+		{name: "warn patched", mode: EnforcementWarn, response: patched(), allowed: true, patched: true},
+		{name: "audit allowed", mode: EnforcementAudit, response: allowed(), allowed: true,
+			warnings: []string{"deprecated"}},
+		{name: "audit denied", mode: EnforcementAudit, response: denied(), allowed: true,
+			annotations: map[string]string{"rule": "r1", "denied": "not allowed", "enforcement-mode": "audit"}},
+		{name: "audit patched", mode: EnforcementAudit, response: patched(), allowed: true, patched: true},
+		{name: "dry-run allowed", mode: EnforcementDryRun, response: allowed(), allowed: true},
+		{name: "dry-run denied", mode: EnforcementDryRun, response: denied(), allowed: true},
+		{name: "dry-run patched", mode: EnforcementDryRun, response: patched(), allowed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := test.mode.Apply(webhook, test.response)
+			if response.Allowed != test.allowed {
+				t.Errorf("Expected allowed=%v, got %v", test.allowed, response.Allowed)
+			}
+			if (len(response.Patch) != 0) != test.patched {
+				t.Errorf("Expected patched=%v, got patch %s", test.patched, response.Patch)
+			}
+			if len(response.Warnings) != 0 || len(test.warnings) != 0 {
+				if !reflect.DeepEqual(response.Warnings, test.warnings) {
+					t.Errorf("Expected warnings %v, got %v", test.warnings, response.Warnings)
+				}
+			}
+			if len(response.AuditAnnotations) != 0 || len(test.annotations) != 0 {
+				if !reflect.DeepEqual(response.AuditAnnotations, test.annotations) {
+					t.Errorf("Expected audit annotations %v, got %v", test.annotations, response.AuditAnnotations)
+				}
+			}
+		})
+	}
+}
+
+func TestEnforcementModesFlag(t *testing.T) {
+	var modes EnforcementModes
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagset.Var(&modes, "enforcement-mode", "")
+	err := flagset.Parse([]string{"--enforcement-mode", "strict=enforce, *=WARN", "--enforcement-mode", "new=dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	declared := enforcedTestWebhook{newTestWebhook("declared", ValidatingAdmissionWebhook, nil), EnforcementAudit}
+	tests := []struct {
+		webhook  AdmissionWebhook
+		modes    EnforcementModes
+		expected EnforcementMode
+	}{
+		{webhook: newTestWebhook("strict", ValidatingAdmissionWebhook, nil), modes: modes, expected: EnforcementEnforce},
+		{webhook: newTestWebhook("new", ValidatingAdmissionWebhook, nil), modes: modes, expected: EnforcementDryRun},
+		{webhook: newTestWebhook("other", ValidatingAdmissionWebhook, nil), modes: modes, expected: EnforcementWarn},
+		// wildcard override mode that webhook declare for itself
+		{webhook: declared, modes: modes, expected: EnforcementWarn},
+		{webhook: declared, expected: EnforcementAudit},
+		{webhook: newTestWebhook("other", ValidatingAdmissionWebhook, nil), expected: EnforcementEnforce},
+	}
+	for _, test := range tests {
+		if mode := test.modes.Get(test.webhook); mode != test.expected {
+			t.Errorf("Expected mode %s for %s with %v, got %s", test.expected, test.webhook.Name(), test.modes, mode)
+		}
+	}
+	if modes.String() != "*=warn,new=dry-run,strict=enforce" {
+		t.Errorf("Unexpected string form %s", modes.String())
+	}
+
+	for _, value := range []string{"strict", "strict=block"} {
+		var invalid EnforcementModes
+		if err := invalid.Set(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestCompositeWebhookChildEnforcementMode(t *testing.T) {
+	deny := func(name string) *testWebhook {
+		return newTestWebhook(name, ValidatingAdmissionWebhook,
+			func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+				return nil, NewPolicyError(DenyResponse(name + " denied"))
+			})
+	}
+	composite, err := NewCompositeWebhook("composite", deny("stable"), deny("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewWebhookRegistry(composite)
+	registry.SetEnforcementModes(EnforcementModes{"new": EnforcementWarn})
+
+	response, err := composite.HandleAdmissionRequest(newCompositeRequest(composite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Allowed || response.Result.Message != "[stable] stable denied" {
+		t.Errorf("Only the enforced child must deny, got %+v", response.Result)
+	}
+	if !reflect.DeepEqual(response.Warnings, []string{"[new] new denied"}) {
+		t.Errorf("Denial of the child in warn mode must be a warning, got %v", response.Warnings)
+	}
+
+	// state of a child override its enforcement mode
+	if err = registry.SetState("new", WebhookEnforced); err != nil {
+		t.Fatal(err)
+	}
+	response, err = composite.HandleAdmissionRequest(newCompositeRequest(composite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Result.Message != "[stable] stable denied; [new] new denied" {
+		t.Errorf("Expected denial of both children, got %+v", response.Result)
+	}
+}
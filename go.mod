@@ -4,8 +4,19 @@ go 1.14
 
 require (
 	github.com/devops-simba/helpers v1.0.15
+	github.com/evanphx/json-patch v4.9.0+incompatible
+	github.com/go-logr/logr v0.2.0
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
-	k8s.io/api v0.18.3
-	k8s.io/apimachinery v0.18.3
-	k8s.io/client-go v0.18.3
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/prometheus/client_golang v1.7.1
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
+	k8s.io/api v0.19.16
+	k8s.io/apimachinery v0.19.16
+	k8s.io/client-go v0.19.16
+	sigs.k8s.io/yaml v1.2.0
 )
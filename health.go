@@ -0,0 +1,60 @@
+package webhook_core
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// LivenessPath path of the liveness probe
+	LivenessPath = "/healthz"
+	// ReadinessPath path of the readiness probe
+	ReadinessPath = "/readyz"
+)
+
+// ServerHealth health state of the server that is reported to the probes
+type ServerHealth struct {
+	ready int32
+}
+
+// NewServerHealth create health state of a server, server is not ready until `SetReady(true)` is called
+func NewServerHealth() *ServerHealth { return &ServerHealth{} }
+
+// IsReady is server ready to receive admission requests
+func (this *ServerHealth) IsReady() bool { return atomic.LoadInt32(&this.ready) != 0 }
+
+// SetReady change readiness of the server
+func (this *ServerHealth) SetReady(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	if atomic.SwapInt32(&this.ready, value) != value {
+		GetLogger().Info("Readiness of the server changed", "ready", ready)
+	}
+}
+
+// LivenessHandler handler of the liveness probe, it always succeed while the server is running
+func (this *ServerHealth) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+}
+
+// ReadinessHandler handler of the readiness probe, it fail before the server is initialized and while the server is
+// shutting down
+func (this *ServerHealth) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !this.IsReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+// RegisterHandlers register handlers of the probes in `mux`
+func (this *ServerHealth) RegisterHandlers(mux *http.ServeMux) {
+	mux.Handle(LivenessPath, this.LivenessHandler())
+	mux.Handle(ReadinessPath, this.ReadinessHandler())
+}
@@ -0,0 +1,399 @@
+package webhook_core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	log "github.com/golang/glog"
+	admissionApi "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// LogFormat format of the log lines
+type LogFormat string
+
+const (
+	// LogFormatText log lines are written by glog as text
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON log lines are written as JSON objects, one per line
+	LogFormatJSON LogFormat = "json"
+)
+
+func (this LogFormat) String() string { return string(this) }
+
+// Set implement `flag.Value`
+func (this *LogFormat) Set(value string) error {
+	switch format := LogFormat(strings.ToLower(value)); format {
+	case LogFormatText, LogFormatJSON:
+		*this = format
+		return nil
+	default:
+		return fmt.Errorf("Invalid log format(%s), valid formats are text and json", value)
+	}
+}
+
+// RedactedValue value that replace sensitive data in the logs
+const RedactedValue = "<redacted>"
+
+var loggerLock sync.RWMutex
+var logger logr.Logger = NewGlogLogger()
+
+// GetLogger get logger of the application
+func GetLogger() logr.Logger {
+	loggerLock.RLock()
+	defer loggerLock.RUnlock()
+
+	return logger
+}
+
+// SetLogger replace logger of the application, any logr compatible logger may be used
+func SetLogger(newLogger logr.Logger) {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+
+	logger = newLogger
+}
+
+// RequestLogger get a logger that add identity of an admission request(UID, webhook, operation, kind, namespace and
+// name) to all of its lines
+func RequestLogger(webhook AdmissionWebhook, ar *admissionApi.AdmissionReview) logr.Logger {
+	result := GetLogger()
+	if webhook != nil {
+		result = result.WithValues("webhook", webhook.Name())
+	}
+	if ar != nil && ar.Request != nil {
+		result = result.WithValues(
+			"uid", ar.Request.UID,
+			"operation", ar.Request.Operation,
+			"kind", formatRequestKind(ar.Request.Kind),
+			"namespace", ar.Request.Namespace,
+			"name", ar.Request.Name)
+	}
+	return result
+}
+
+// SensitiveKinds kinds of the objects that their content must be redacted before they are logged. It implement
+// `flag.Value` and parse values like `v1/ConfigMap,example.com/*/Token`, `*` as version match all versions
+type SensitiveKinds []metav1.GroupVersionKind
+
+func (this SensitiveKinds) String() string {
+	items := make([]string, 0, len(this))
+	for _, kind := range this {
+		if kind.Version == "" {
+			kind.Version = "*"
+		}
+		items = append(items, formatRequestKind(kind))
+	}
+	return strings.Join(items, ",")
+}
+
+// Set implement `flag.Value`
+func (this *SensitiveKinds) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var kind metav1.GroupVersionKind
+		switch parts := strings.Split(item, "/"); len(parts) {
+		case 2:
+			kind = metav1.GroupVersionKind{Version: parts[0], Kind: parts[1]}
+		case 3:
+			kind = metav1.GroupVersionKind{Group: parts[0], Version: parts[1], Kind: parts[2]}
+		default:
+			return fmt.Errorf("Invalid kind(%s), expected version/kind or group/version/kind", item)
+		}
+		if kind.Version == "" || kind.Kind == "" {
+			return fmt.Errorf("Invalid kind(%s), expected version/kind or group/version/kind", item)
+		}
+		if kind.Version == "*" {
+			kind.Version = ""
+		}
+		*this = append(*this, kind)
+	}
+	return nil
+}
+
+// Match is `kind` one of the sensitive kinds
+func (this SensitiveKinds) Match(kind metav1.GroupVersionKind) bool {
+	for _, sensitiveKind := range this {
+		if sensitiveKind.Group == kind.Group && sensitiveKind.Kind == kind.Kind &&
+			(sensitiveKind.Version == "" || sensitiveKind.Version == kind.Version) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSensitiveKinds kinds that are always redacted, that is Secrets of all versions
+var DefaultSensitiveKinds = SensitiveKinds{{Kind: "Secret"}}
+
+var sensitiveKindsLock sync.RWMutex
+var sensitiveKinds SensitiveKinds
+
+// SetSensitiveKinds set kinds that are redacted in the logs and audit records in addition to `DefaultSensitiveKinds`
+func SetSensitiveKinds(kinds SensitiveKinds) {
+	sensitiveKindsLock.Lock()
+	defer sensitiveKindsLock.Unlock()
+
+	sensitiveKinds = append(SensitiveKinds{}, kinds...)
+}
+
+// IsSensitiveKind should content of objects of this kind redacted before they are logged
+func IsSensitiveKind(kind metav1.GroupVersionKind) bool {
+	if DefaultSensitiveKinds.Match(kind) {
+		return true
+	}
+
+	sensitiveKindsLock.RLock()
+	defer sensitiveKindsLock.RUnlock()
+
+	return sensitiveKinds.Match(kind)
+}
+
+// isSensitiveRequest is this a request for an object of a sensitive kind
+func isSensitiveRequest(request *admissionApi.AdmissionRequest) bool {
+	return request != nil && IsSensitiveKind(request.Kind)
+}
+
+// redactPatch get patch of a response to `ar` so it can be logged
+func redactPatch(ar *admissionApi.AdmissionReview, patch []byte) string {
+	if len(patch) != 0 && ar != nil && isSensitiveRequest(ar.Request) {
+		return RedactedValue
+	}
+	return string(patch)
+}
+
+// redactObject remove content of an object of a sensitive kind that is encoded in `raw`, only its type and metadata
+// and keys of its maps(e.g. keys of `data` of a Secret) are kept
+func redactObject(raw runtime.RawExtension) runtime.RawExtension {
+	if len(raw.Raw) == 0 {
+		return raw
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(raw.Raw, &object); err != nil {
+		return runtime.RawExtension{Raw: []byte(`"` + RedactedValue + `"`)}
+	}
+	for key, value := range object {
+		switch key {
+		case "apiVersion", "kind":
+		case "metadata":
+			if metadata, ok := value.(map[string]interface{}); ok {
+				if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+					// kubectl keep the whole object in this annotation
+					if _, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+						annotations["kubectl.kubernetes.io/last-applied-configuration"] = RedactedValue
+					}
+				}
+			}
+		default:
+			if data, ok := value.(map[string]interface{}); ok {
+				for name := range data {
+					data[name] = RedactedValue
+				}
+			} else {
+				object[key] = RedactedValue
+			}
+		}
+	}
+
+	redacted, err := json.Marshal(object)
+	if err != nil {
+		return runtime.RawExtension{Raw: []byte(`"` + RedactedValue + `"`)}
+	}
+	return runtime.RawExtension{Raw: redacted}
+}
+
+// RedactAdmissionReview get a copy of an admission review that sensitive data(content of objects of sensitive kinds
+// and their patches) removed from it, so it can be logged
+func RedactAdmissionReview(ar *admissionApi.AdmissionReview) *admissionApi.AdmissionReview {
+	if ar == nil {
+		return nil
+	}
+
+	result := ar.DeepCopy()
+	if isSensitiveRequest(result.Request) {
+		result.Request.Object = redactObject(result.Request.Object)
+		result.Request.OldObject = redactObject(result.Request.OldObject)
+		if result.Response != nil && len(result.Response.Patch) != 0 {
+			result.Response.Patch = []byte(RedactedValue)
+		}
+	}
+	return result
+}
+
+// formatLogValue format a value so it can be logged
+func formatLogValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case []byte:
+		return string(v)
+	}
+	return value
+}
+
+// glogLogger a `logr.Logger` that write to glog
+type glogLogger struct {
+	level  int
+	name   string
+	values []interface{}
+}
+
+// NewGlogLogger create a logger that write text lines to glog, values are written as `key=value`
+func NewGlogLogger() logr.Logger { return glogLogger{} }
+
+func (this glogLogger) format(msg string, keysAndValues []interface{}) string {
+	var buf bytes.Buffer
+	if this.name != "" {
+		buf.WriteString(this.name)
+		buf.WriteString(": ")
+	}
+	buf.WriteString(msg)
+	values := append(append([]interface{}{}, this.values...), keysAndValues...)
+	for i := 0; i < len(values); i += 2 {
+		var value interface{} = "<missing>"
+		if i+1 < len(values) {
+			value = formatLogValue(values[i+1])
+		}
+		fmt.Fprintf(&buf, " %v=%q", values[i], fmt.Sprint(value))
+	}
+	return buf.String()
+}
+func (this glogLogger) Enabled() bool { return bool(log.V(log.Level(this.level))) }
+func (this glogLogger) Info(msg string, keysAndValues ...interface{}) {
+	if this.Enabled() {
+		log.InfoDepth(1, this.format(msg, keysAndValues))
+	}
+}
+func (this glogLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	if err != nil {
+		keysAndValues = append(keysAndValues, "error", err)
+	}
+	log.ErrorDepth(1, this.format(msg, keysAndValues))
+}
+func (this glogLogger) V(level int) logr.Logger {
+	this.level += level
+	return this
+}
+func (this glogLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	this.values = append(append([]interface{}{}, this.values...), keysAndValues...)
+	return this
+}
+func (this glogLogger) WithName(name string) logr.Logger {
+	if this.name != "" {
+		name = this.name + "." + name
+	}
+	this.name = name
+	return this
+}
+
+// jsonLogger a `logr.Logger` that write JSON lines to a writer
+type jsonLogger struct {
+	lock      *sync.Mutex
+	writer    io.Writer
+	verbosity int
+	level     int
+	name      string
+	values    []interface{}
+}
+
+// NewJSONLogger create a logger that write each line as a JSON object to `writer`, lines with a level greater than
+// `verbosity` are ignored
+func NewJSONLogger(writer io.Writer, verbosity int) logr.Logger {
+	return jsonLogger{lock: &sync.Mutex{}, writer: writer, verbosity: verbosity}
+}
+
+func (this jsonLogger) write(severity string, msg string, keysAndValues []interface{}) {
+	line := map[string]interface{}{
+		"ts":       time.Now().UTC().Format(time.RFC3339Nano),
+		"severity": severity,
+		"v":        this.level,
+		"msg":      msg,
+	}
+	if this.name != "" {
+		line["logger"] = this.name
+	}
+	values := append(append([]interface{}{}, this.values...), keysAndValues...)
+	for i := 0; i < len(values); i += 2 {
+		var value interface{} = "<missing>"
+		if i+1 < len(values) {
+			value = formatLogValue(values[i+1])
+		}
+		line[fmt.Sprint(values[i])] = value
+	}
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{
+			"ts":       line["ts"],
+			"severity": "error",
+			"msg":      "Failed to encode log line",
+			"error":    err.Error(),
+			"line":     fmt.Sprint(line),
+		})
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.writer.Write(append(data, '\n'))
+}
+func (this jsonLogger) Enabled() bool { return this.level <= this.verbosity }
+func (this jsonLogger) Info(msg string, keysAndValues ...interface{}) {
+	if this.Enabled() {
+		this.write("info", msg, keysAndValues)
+	}
+}
+func (this jsonLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	if err != nil {
+		keysAndValues = append(keysAndValues, "error", err)
+	}
+	this.write("error", msg, keysAndValues)
+}
+func (this jsonLogger) V(level int) logr.Logger {
+	this.level += level
+	return this
+}
+func (this jsonLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	this.values = append(append([]interface{}{}, this.values...), keysAndValues...)
+	return this
+}
+func (this jsonLogger) WithName(name string) logr.Logger {
+	if this.name != "" {
+		name = this.name + "." + name
+	}
+	this.name = name
+	return this
+}
+
+// logAdmissionReview dump an admission review, after removing its sensitive data
+func logAdmissionReview(logger logr.Logger, msg string, ar *admissionApi.AdmissionReview) {
+	if !logger.Enabled() {
+		return
+	}
+	data, err := json.Marshal(RedactAdmissionReview(ar))
+	if err != nil {
+		logger.Error(err, "Failed to encode admission review for logging")
+		return
+	}
+	logger.Info(msg, "review", string(data))
+}
+
+// formatRequestKind format kind of a request as `group/version/kind`, or `version/kind` for the core group
+func formatRequestKind(kind metav1.GroupVersionKind) string {
+	if kind.Group == "" {
+		return kind.Version + "/" + kind.Kind
+	}
+	return kind.Group + "/" + kind.Version + "/" + kind.Kind
+}
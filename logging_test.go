@@ -0,0 +1,175 @@
+package webhook_core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionApi "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAdmissionLogLinesCarryRequestIdentity(t *testing.T) {
+	var output bytes.Buffer
+	previous := GetLogger()
+	SetLogger(NewJSONLogger(&output, 10))
+	defer SetLogger(previous)
+
+	webhook := newTestWebhook("test-webhook", MutatingAdmissionWebhook, nil)
+	apiVersion, ar, err := ReadAdmissionReviewWithOptions(
+		newAdmissionHTTPRequest(admissionReviewBody(verAdmissionApi, "AdmissionReview")),
+		AdmissionReadOptions{Webhook: webhook})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := CreatePatchResponse([]PatchOperation{NewAddPatch("/metadata/labels", map[string]string{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteAdmissionResponseWithSerializer(httptest.NewRecorder(), GetAdmissionSerializer(jsonMIME), webhook,
+		apiVersion, ar, response)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	messages := make(map[string]bool)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Invalid log line %s: %v", line, err)
+		}
+		msg, _ := entry["msg"].(string)
+		messages[msg] = true
+		if msg == "Converting to JSONPatch" {
+			// patch builder does not know the request, content of the patch is logged with the response
+			if _, ok := entry["patch"]; ok {
+				t.Errorf("Patch content must not be logged without redaction: %s", line)
+			}
+			continue
+		}
+		for _, field := range []string{"webhook", "uid", "operation", "kind", "namespace", "name"} {
+			if _, ok := entry[field]; !ok {
+				t.Errorf("Log line %q is missing %s: %s", msg, field, line)
+			}
+		}
+	}
+	for _, msg := range []string{"Received admission review", "Sent response"} {
+		if !messages[msg] {
+			t.Errorf("Expected a %q line in %s", msg, output.String())
+		}
+	}
+}
+
+func TestSensitiveKindsFlag(t *testing.T) {
+	var kinds SensitiveKinds
+	if err := kinds.Set("v1/ConfigMap, example.com/*/Widget"); err != nil {
+		t.Fatal(err)
+	}
+	if kinds.String() != "v1/ConfigMap,example.com/*/Widget" {
+		t.Errorf("Unexpected string form %s", kinds.String())
+	}
+
+	tests := map[metav1.GroupVersionKind]bool{
+		{Version: "v1", Kind: "ConfigMap"}:                         true,
+		{Version: "v2", Kind: "ConfigMap"}:                         false,
+		{Group: "example.com", Version: "v1beta1", Kind: "Widget"}: true,
+		{Group: "other.com", Version: "v1", Kind: "Widget"}:        false,
+	}
+	for kind, expected := range tests {
+		if kinds.Match(kind) != expected {
+			t.Errorf("Expected match of %v to be %v", kind, expected)
+		}
+	}
+
+	for _, value := range []string{"Secret", "a/b/c/d", "/Secret", "v1/"} {
+		var invalid SensitiveKinds
+		if err := invalid.Set(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestRedactAdmissionReview(t *testing.T) {
+	SetSensitiveKinds(SensitiveKinds{{Group: "example.com", Kind: "Widget"}})
+	defer SetSensitiveKinds(nil)
+
+	secret := `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"token","annotations":{` +
+		`"kubectl.kubernetes.io/last-applied-configuration":"{\"data\":{\"token\":\"c2VjcmV0\"}}"}},` +
+		`"data":{"token":"c2VjcmV0"},"type":"Opaque"}`
+	tests := []struct {
+		name     string
+		kind     metav1.GroupVersionKind
+		object   string
+		redacted bool
+	}{
+		{name: "secret", kind: metav1.GroupVersionKind{Version: "v1", Kind: "Secret"}, object: secret, redacted: true},
+		{name: "registered kind", kind: metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"},
+			object: testWidget, redacted: true},
+		{name: "other kind", kind: metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}, object: testPod},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ar := &admissionApi.AdmissionReview{
+				Request: &admissionApi.AdmissionRequest{
+					Kind:      test.kind,
+					Object:    runtime.RawExtension{Raw: []byte(test.object)},
+					OldObject: runtime.RawExtension{Raw: []byte(test.object)},
+				},
+				Response: &admissionApi.AdmissionResponse{Patch: []byte(`[{"op":"add","path":"/spec","value":{}}]`)},
+			}
+			redacted := RedactAdmissionReview(ar)
+			if string(ar.Request.Object.Raw) != test.object {
+				t.Error("Original review must not change")
+			}
+
+			for _, raw := range []runtime.RawExtension{redacted.Request.Object, redacted.Request.OldObject} {
+				if !test.redacted {
+					if string(raw.Raw) != test.object {
+						t.Errorf("Objects of other kinds must not change, got %s", raw.Raw)
+					}
+					continue
+				}
+				if strings.Contains(string(raw.Raw), "c2VjcmV0") {
+					t.Errorf("Content of the object is not redacted: %s", raw.Raw)
+				}
+				var object map[string]interface{}
+				if err := json.Unmarshal(raw.Raw, &object); err != nil {
+					t.Fatal(err)
+				}
+				if object["kind"] != test.kind.Kind || object["metadata"].(map[string]interface{})["name"] == nil {
+					t.Errorf("Type and metadata of the object must be kept: %s", raw.Raw)
+				}
+				for key, value := range object {
+					if data, ok := value.(map[string]interface{}); ok && key != "metadata" {
+						for name, value := range data {
+							if value != RedactedValue {
+								t.Errorf("Value of %s.%s is not redacted: %v", key, name, value)
+							}
+						}
+					} else if !ok && key != "apiVersion" && key != "kind" && value != RedactedValue {
+						t.Errorf("Value of %s is not redacted: %v", key, value)
+					}
+				}
+			}
+			if patched := string(redacted.Response.Patch) == RedactedValue; patched != test.redacted {
+				t.Errorf("Expected patch to be redacted=%v, got %s", test.redacted, redacted.Response.Patch)
+			}
+		})
+	}
+}
+
+func TestReadEnvLogThroughLogger(t *testing.T) {
+	var output bytes.Buffer
+	previous := GetLogger()
+	SetLogger(NewJSONLogger(&output, 0))
+	defer SetLogger(previous)
+
+	setEnv(t, map[string]string{"TEST_INVALID_INT": "many"})
+	if value := readEnvInt("TEST_INVALID_INT", 7); value != 7 {
+		t.Errorf("Expected default value for an invalid value, got %d", value)
+	}
+	if !strings.Contains(output.String(), `"name":"TEST_INVALID_INT"`) {
+		t.Errorf("Invalid value is not logged through the logger: %s", output.String())
+	}
+}
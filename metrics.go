@@ -0,0 +1,38 @@
+package webhook_core
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsNamespace namespace of the metrics of this library
+const MetricsNamespace = "admission_webhook"
+
+// MetricsPath path that metrics are served on it
+const MetricsPath = "/metrics"
+
+// MetricsRegistry registry of the metrics of the server, webhooks may register their own metrics in it
+var MetricsRegistry = prometheus.NewRegistry()
+
+var (
+	clientAuthRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "client_auth_rejections_total",
+		Help:      "Number of the requests that rejected because their client certificate is missing or not accepted",
+	}, []string{"reason"})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		clientAuthRejections,
+	)
+}
+
+// MetricsHandler handler that serve metrics of `MetricsRegistry`
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(MetricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,237 @@
+package webhook_core
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// ParseTLSVersion parse a TLS version like 1.2 or 1.3
+func ParseTLSVersion(value string) (uint16, error) {
+	if version, ok := tlsVersions[strings.TrimPrefix(strings.ToUpper(value), "TLS")]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("Invalid TLS version(%s), valid versions are 1.0, 1.1, 1.2 and 1.3", value)
+}
+
+// ParseCipherSuites parse name of cipher suites(e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), insecure suites are
+// only accepted if `allowInsecure` is true
+func ParseCipherSuites(names []string, allowInsecure bool) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	insecureSuites := make(map[string]uint16)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecureSuites[suite.Name] = suite.ID
+	}
+
+	result := make([]uint16, 0, len(names))
+	for _, name := range names {
+		if id, ok := suites[name]; ok {
+			result = append(result, id)
+		} else if id, ok := insecureSuites[name]; ok && allowInsecure {
+			result = append(result, id)
+		} else if ok {
+			return nil, fmt.Errorf("Cipher suite %s is insecure", name)
+		} else {
+			return nil, fmt.Errorf("Unknown cipher suite %s", name)
+		}
+	}
+	return result, nil
+}
+
+// ParseCurves parse name of elliptic curves, valid names are X25519, P256, P384 and P521
+func ParseCurves(names []string) ([]tls.CurveID, error) {
+	result := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		curve, ok := tlsCurves[strings.ToUpper(name)]
+		if !ok {
+			validNames := make([]string, 0, len(tlsCurves))
+			for validName := range tlsCurves {
+				validNames = append(validNames, validName)
+			}
+			sort.Strings(validNames)
+			return nil, fmt.Errorf("Unknown curve %s, valid curves are %s", name, strings.Join(validNames, ", "))
+		}
+		result = append(result, curve)
+	}
+	return result, nil
+}
+
+// commaSeparatedList a `flag.Value` that read a comma separated list of strings
+type commaSeparatedList []string
+
+func (this *commaSeparatedList) String() string { return strings.Join(*this, ",") }
+func (this *commaSeparatedList) Set(value string) error {
+	*this = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*this = append(*this, item)
+		}
+	}
+	return nil
+}
+
+// ServerOptions options of the HTTP server that serve admission requests
+type ServerOptions struct {
+	// ReadHeaderTimeout time that clients have to send headers of a request
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout time that clients have to send a whole request
+	ReadTimeout time.Duration
+	// WriteTimeout maximum time of handling a request and writing its response, it must be longer than timeout of the
+	// webhooks
+	WriteTimeout time.Duration
+	// IdleTimeout time that an idle keep-alive connection is kept open
+	IdleTimeout time.Duration
+	// TLSMinVersion minimum accepted version of TLS, e.g. 1.2
+	TLSMinVersion string
+	// TLSMaxVersion maximum accepted version of TLS, empty means default of Go
+	TLSMaxVersion string
+	// CipherSuites name of the accepted cipher suites for TLS 1.2 and older, empty means defaults of Go
+	CipherSuites []string
+	// AllowInsecureCipherSuites accept cipher suites that are known to be insecure in `CipherSuites`
+	AllowInsecureCipherSuites bool
+	// CurvePreferences name of the accepted elliptic curves in order of preference, empty means defaults of Go
+	CurvePreferences []string
+	// DisableHTTP2 serve requests only using HTTP/1.1
+	DisableHTTP2 bool
+	// HTTP2MaxConcurrentStreams maximum number of concurrent streams of each HTTP/2 connection, zero means default
+	HTTP2MaxConcurrentStreams uint32
+}
+
+// BindToFlags bind these options to command line flags
+func (this *ServerOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.DurationVar(&this.ReadHeaderTimeout, "read-header-timeout", 10*time.Second,
+		"Time that clients have to send headers of a request")
+	flagset.DurationVar(&this.ReadTimeout, "read-timeout", 30*time.Second,
+		"Time that clients have to send a whole request")
+	flagset.DurationVar(&this.WriteTimeout, "write-timeout", time.Duration(MaxTimeoutInSeconds+10)*time.Second,
+		"Maximum time of handling a request and writing its response, it must be longer than timeout of webhooks")
+	flagset.DurationVar(&this.IdleTimeout, "idle-timeout", 120*time.Second,
+		"Time that an idle keep-alive connection is kept open")
+	flagset.StringVar(&this.TLSMinVersion, "tls-min-version", "1.2",
+		"Minimum accepted version of TLS, one of 1.0, 1.1, 1.2 or 1.3")
+	flagset.StringVar(&this.TLSMaxVersion, "tls-max-version", "",
+		"Maximum accepted version of TLS, one of 1.0, 1.1, 1.2 or 1.3, default is default of Go")
+	flagset.Var((*commaSeparatedList)(&this.CipherSuites), "tls-cipher-suites",
+		"Comma separated list of accepted cipher suites for TLS 1.2 and older, default is defaults of Go")
+	flagset.BoolVar(&this.AllowInsecureCipherSuites, "tls-allow-insecure-cipher-suites", false,
+		"Accept cipher suites that are known to be insecure in tls-cipher-suites")
+	flagset.Var((*commaSeparatedList)(&this.CurvePreferences), "tls-curve-preferences",
+		"Comma separated list of accepted elliptic curves(X25519, P256, P384, P521) in order of preference")
+	flagset.BoolVar(&this.DisableHTTP2, "disable-http2", false, "Serve requests only using HTTP/1.1")
+	flagset.Var(uint32Value{&this.HTTP2MaxConcurrentStreams}, "http2-max-concurrent-streams",
+		"Maximum number of concurrent streams of each HTTP/2 connection, 0 means default")
+}
+
+// BuildTLSConfig build TLS configuration of the server from these options
+func (this ServerOptions) BuildTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if this.TLSMinVersion != "" {
+		version, err := ParseTLSVersion(this.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+		config.MinVersion = version
+	}
+	if this.TLSMaxVersion != "" {
+		version, err := ParseTLSVersion(this.TLSMaxVersion)
+		if err != nil {
+			return nil, err
+		}
+		if version < config.MinVersion {
+			return nil, fmt.Errorf("Maximum TLS version(%s) is lower than the minimum version", this.TLSMaxVersion)
+		}
+		config.MaxVersion = version
+	}
+
+	if len(this.CipherSuites) != 0 {
+		suites, err := ParseCipherSuites(this.CipherSuites, this.AllowInsecureCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = suites
+	}
+
+	if len(this.CurvePreferences) != 0 {
+		curves, err := ParseCurves(this.CurvePreferences)
+		if err != nil {
+			return nil, err
+		}
+		config.CurvePreferences = curves
+	}
+
+	if this.DisableHTTP2 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+	return config, nil
+}
+
+// Apply apply these options to a server, `useTLS` specify if server will serve TLS
+func (this ServerOptions) Apply(server *http.Server, useTLS bool) error {
+	server.ReadHeaderTimeout = this.ReadHeaderTimeout
+	server.ReadTimeout = this.ReadTimeout
+	server.WriteTimeout = this.WriteTimeout
+	server.IdleTimeout = this.IdleTimeout
+	if !useTLS {
+		return nil
+	}
+
+	tlsConfig, err := this.BuildTLSConfig()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+
+	if this.DisableHTTP2 {
+		// a non-nil empty map disable automatic HTTP/2 support of the server
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+	return http2.ConfigureServer(server, &http2.Server{
+		MaxConcurrentStreams: this.HTTP2MaxConcurrentStreams,
+		IdleTimeout:          this.IdleTimeout,
+	})
+}
+
+// uint32Value a `flag.Value` for uint32 values
+type uint32Value struct {
+	value *uint32
+}
+
+func (this uint32Value) String() string {
+	if this.value == nil {
+		return "0"
+	}
+	return fmt.Sprint(*this.value)
+}
+func (this uint32Value) Set(value string) error {
+	result, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	*this.value = uint32(result)
+	return nil
+}
@@ -0,0 +1,161 @@
+package webhook_core
+
+import (
+	"crypto/tls"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := map[string]uint16{
+		"1.0":    tls.VersionTLS10,
+		"1.2":    tls.VersionTLS12,
+		"TLS1.3": tls.VersionTLS13,
+		"tls1.1": tls.VersionTLS11,
+		"1.4":    0,
+		"SSL3.0": 0,
+		"":       0,
+	}
+	for value, expected := range tests {
+		version, err := ParseTLSVersion(value)
+		if expected == 0 {
+			if err == nil {
+				t.Errorf("Expected an error for %q", value)
+			}
+		} else if err != nil || version != expected {
+			t.Errorf("Expected %x for %q, got %x(%v)", expected, value, version, err)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	secure := tls.CipherSuites()[0]
+	insecure := tls.InsecureCipherSuites()[0]
+	tests := []struct {
+		name          string
+		names         []string
+		allowInsecure bool
+		expected      []uint16
+		invalid       bool
+	}{
+		{name: "empty", expected: []uint16{}},
+		{name: "secure", names: []string{secure.Name}, expected: []uint16{secure.ID}},
+		{name: "insecure", names: []string{secure.Name, insecure.Name}, invalid: true},
+		{name: "allowed insecure", names: []string{insecure.Name, secure.Name}, allowInsecure: true,
+			expected: []uint16{insecure.ID, secure.ID}},
+		{name: "unknown", names: []string{"TLS_NULL_WITH_NULL_NULL"}, allowInsecure: true, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			suites, err := ParseCipherSuites(test.names, test.allowInsecure)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", suites)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(suites, test.expected) {
+				t.Errorf("Expected %v, got %v(%v)", test.expected, suites, err)
+			}
+		})
+	}
+}
+
+func TestParseCurves(t *testing.T) {
+	curves, err := ParseCurves([]string{"x25519", "P384"})
+	if err != nil || !reflect.DeepEqual(curves, []tls.CurveID{tls.X25519, tls.CurveP384}) {
+		t.Errorf("Unexpected curves %v(%v)", curves, err)
+	}
+	if _, err = ParseCurves([]string{"P256", "P224"}); err == nil {
+		t.Error("Expected an error for an unknown curve")
+	}
+}
+
+func TestServerOptionsBuildTLSConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  ServerOptions
+		min, max uint16
+		invalid  bool
+	}{
+		{name: "defaults", min: tls.VersionTLS12},
+		{name: "range", options: ServerOptions{TLSMinVersion: "1.2", TLSMaxVersion: "1.3"},
+			min: tls.VersionTLS12, max: tls.VersionTLS13},
+		{name: "max equal min", options: ServerOptions{TLSMinVersion: "1.3", TLSMaxVersion: "1.3"},
+			min: tls.VersionTLS13, max: tls.VersionTLS13},
+		{name: "min above max", options: ServerOptions{TLSMinVersion: "1.3", TLSMaxVersion: "1.2"}, invalid: true},
+		{name: "max below default min", options: ServerOptions{TLSMaxVersion: "1.1"}, invalid: true},
+		{name: "invalid min", options: ServerOptions{TLSMinVersion: "2.0"}, invalid: true},
+		{name: "invalid max", options: ServerOptions{TLSMaxVersion: "2.0"}, invalid: true},
+		{name: "insecure suite", options: ServerOptions{CipherSuites: []string{tls.InsecureCipherSuites()[0].Name}},
+			invalid: true},
+		{name: "unknown curve", options: ServerOptions{CurvePreferences: []string{"P224"}}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.options.BuildTLSConfig()
+			if test.invalid {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.MinVersion != test.min || config.MaxVersion != test.max {
+				t.Errorf("Expected versions %x-%x, got %x-%x", test.min, test.max, config.MinVersion, config.MaxVersion)
+			}
+		})
+	}
+}
+
+func TestServerOptionsApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   ServerOptions
+		useTLS    bool
+		http2     bool
+		protocols []string
+	}{
+		{name: "plain", options: ServerOptions{DisableHTTP2: true}},
+		{name: "http2", useTLS: true, http2: true, protocols: []string{"h2"}},
+		{name: "http2 disabled", options: ServerOptions{DisableHTTP2: true}, useTLS: true,
+			protocols: []string{"http/1.1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.ReadTimeout = time.Second
+			server := &http.Server{}
+			if err := test.options.Apply(server, test.useTLS); err != nil {
+				t.Fatal(err)
+			}
+			if server.ReadTimeout != time.Second {
+				t.Errorf("Timeouts are not applied, got %v", server.ReadTimeout)
+			}
+			if !test.useTLS {
+				if server.TLSConfig != nil || server.TLSNextProto != nil {
+					t.Error("TLS must not be configured for a plain server")
+				}
+				return
+			}
+			if _, ok := server.TLSNextProto["h2"]; ok != test.http2 {
+				t.Errorf("Expected HTTP/2 handler to be %v, got %v", test.http2, server.TLSNextProto)
+			}
+			if !test.http2 && server.TLSNextProto == nil {
+				// nil map let the server enable HTTP/2 by itself
+				t.Error("TLSNextProto must be an empty map when HTTP/2 is disabled")
+			}
+			if !reflect.DeepEqual(server.TLSConfig.NextProtos, test.protocols) {
+				t.Errorf("Expected protocols %v, got %v", test.protocols, server.TLSConfig.NextProtos)
+			}
+		})
+	}
+
+	invalid := ServerOptions{CurvePreferences: []string{"P224"}}
+	if err := invalid.Apply(&http.Server{}, true); err == nil {
+		t.Error("Expected an error for invalid TLS options")
+	}
+}
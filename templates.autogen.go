@@ -134,11 +134,15 @@ var DeploymentTemplate = template.Must(ParseTemplate("Deployment", strings.Join(
     "      labels:",
     "        app: \"{{ .Name }}\"",
     "    spec:",
+    "      {{ if .ServiceUser -}}",
+    "      serviceAccountName: \"{{ .ServiceUser }}\"",
+    "      {{ end -}}",
     "      {{ if (ne .RunAsUser 0) -}}",
     "      securityContext:",
     "        runAsNonRoot: true",
     "        runAsUser: {{ .RunAsUser }}",
     "      {{ end -}}",
+    "      terminationGracePeriodSeconds: {{ .TerminationGracePeriodSeconds }}",
     "      containers:",
     "        - name: \"server\"",
     "          image: \"{{ if .ImageRegistry }}{{ .ImageRegistry }}/{{ end }}{{ .ImageName }}:{{ .ImageTag }}\"",
@@ -159,10 +163,54 @@ var DeploymentTemplate = template.Must(ParseTemplate("Deployment", strings.Join(
     "            {{ else }}",
     "            - \"--insecure\"",
     "            {{- end }}",
+    "            - \"--drain-period\"",
+    "            - \"{{ .DrainPeriod }}\"",
+    "            - \"--shutdown-timeout\"",
+    "            - \"{{ .ShutdownTimeout }}\"",
+    "            {{- if (ne .MetricsPort 0) }}",
+    "            - \"--metrics-port\"",
+    "            - \"{{ .MetricsPort }}\"",
+    "            {{- end }}",
+    "            {{- range .WatchedObjects }}",
+    "            - \"--{{ .Flag }}\"",
+    "            - \"{{ .Namespace }}/{{ .Name }}\"",
+    "            {{- end }}",
     "          imagePullPolicy: Always",
     "          ports:",
     "            - containerPort: {{ .ContainerPort }}",
     "              name: \"{{ .Name }}-api\"",
+    "            {{- if (ne .MetricsPort 0) }}",
+    "            - containerPort: {{ .MetricsPort }}",
+    "              name: \"metrics\"",
+    "            {{- end }}",
+    "          livenessProbe:",
+    "            httpGet:",
+    "              path: /healthz",
+    "              {{- if (ne .MetricsPort 0) }}",
+    "              port: \"metrics\"",
+    "              scheme: HTTP",
+    "              {{- else }}",
+    "              port: \"{{ .Name }}-api\"",
+    "              scheme: {{ if .Insecure }}HTTP{{ else }}HTTPS{{ end }}",
+    "              {{- end }}",
+    "          readinessProbe:",
+    "            httpGet:",
+    "              path: /readyz",
+    "              {{- if (ne .MetricsPort 0) }}",
+    "              port: \"metrics\"",
+    "              scheme: HTTP",
+    "              {{- else }}",
+    "              port: \"{{ .Name }}-api\"",
+    "              scheme: {{ if .Insecure }}HTTP{{ else }}HTTPS{{ end }}",
+    "              {{- end }}",
+    "            periodSeconds: 2",
+    "            failureThreshold: 1",
+    "          {{ if (ne .PreStopDelaySeconds 0) -}}",
+    "          lifecycle:",
+    "            preStop:",
+    "              exec:",
+    "                command: [\"sleep\", \"{{ .PreStopDelaySeconds }}\"]",
+    "          {{ end -}}",
     "          env:",
     "            {{ range .AllHooks }}{{ range .Configurations }}{{ if (ne .DefaultValue nil) -}}",
     "            {{ if (ne .Desc \"\") }}# {{ .Desc }}{{ end }}",
@@ -188,12 +236,48 @@ var DeploymentTemplate = template.Must(ParseTemplate("Deployment", strings.Join(
     "  labels:",
     "    app: \"{{ .Name }}\"",
     "spec:",
-    "  {{ if .ServiceUser }}serviceAccountName: {{ .ServiceUser }}{{ end }}",
     "  selector:",
     "    app: \"{{ .Name }}\"",
     "  ports:",
     "    - port: {{ .ServerPort }}",
     "      targetPort: \"{{ .Name }}-api\"",
+    "      name: \"api\"",
+    "    {{- if (ne .MetricsPort 0) }}",
+    "    - port: {{ .MetricsPort }}",
+    "      targetPort: \"metrics\"",
+    "      name: \"metrics\"",
+    "    {{- end }}",
+    "{{- range .WatchedObjects }}",
+    "---",
+    "apiVersion: rbac.authorization.k8s.io/v1",
+    "kind: Role",
+    "metadata:",
+    "  name: \"{{ $.Name }}-{{ .Flag }}\"",
+    "  namespace: \"{{ .Namespace }}\"",
+    "  labels:",
+    "    app: \"{{ $.Name }}\"",
+    "rules:",
+    "  - apiGroups: [\"\"]",
+    "    resources: [\"{{ .Resource }}\"]",
+    "    resourceNames: [\"{{ .Name }}\"]",
+    "    verbs: [\"get\", \"list\", \"watch\"]",
+    "---",
+    "apiVersion: rbac.authorization.k8s.io/v1",
+    "kind: RoleBinding",
+    "metadata:",
+    "  name: \"{{ $.Name }}-{{ .Flag }}\"",
+    "  namespace: \"{{ .Namespace }}\"",
+    "  labels:",
+    "    app: \"{{ $.Name }}\"",
+    "roleRef:",
+    "  apiGroup: rbac.authorization.k8s.io",
+    "  kind: Role",
+    "  name: \"{{ $.Name }}-{{ .Flag }}\"",
+    "subjects:",
+    "  - kind: ServiceAccount",
+    "    name: \"{{ if $.ServiceUser }}{{ $.ServiceUser }}{{ else }}default{{ end }}\"",
+    "    namespace: \"{{ $.Namespace }}\"",
+    "{{- end }}",
     "{{if (ne 0 (len .MutatingWebhooks)) -}}",
     "---",
     "apiVersion: admissionregistration.k8s.io/v1",
@@ -33,22 +33,37 @@ type WebhookData struct {
 }
 
 type DeploymentData struct {
-	Name               string
-	Namespace          string
-	RunAsUser          int
-	LogLevel           int
-	ImageRegistry      string
-	ImageName          string
-	ImageTag           string
-	ContainerPort      int
-	ServerPort         int
-	Insecure           bool
-	CABundle           string
-	TlsSecretName      string
-	ServiceName        string
-	ServiceUser        string
-	MutatingWebhooks   []WebhookData
-	ValidatingWebhooks []WebhookData
+	Name                          string
+	Namespace                     string
+	RunAsUser                     int
+	LogLevel                      int
+	ImageRegistry                 string
+	ImageName                     string
+	ImageTag                      string
+	ContainerPort                 int
+	ServerPort                    int
+	MetricsPort                   int
+	Insecure                      bool
+	CABundle                      string
+	TlsSecretName                 string
+	ServiceName                   string
+	ServiceUser                   string
+	DrainPeriod                   string
+	ShutdownTimeout               string
+	PreStopDelaySeconds           int
+	TerminationGracePeriodSeconds int
+	WatchedObjects                []WatchedObjectData
+	MutatingWebhooks              []WebhookData
+	ValidatingWebhooks            []WebhookData
+}
+
+// WatchedObjectData an object that the server watch at runtime, so it need permission to read it
+type WatchedObjectData struct {
+	// Flag command line flag that pass this object to the server
+	Flag      string
+	Resource  string
+	Namespace string
+	Name      string
 }
 
 func (this DeploymentData) AllHooks() []WebhookData {
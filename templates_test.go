@@ -0,0 +1,76 @@
+package webhook_core
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestRenderDeploymentGrantAccessToWatchedObjects(t *testing.T) {
+	rendered, err := RenderDeployment(DeploymentData{
+		Name:          "app",
+		Namespace:     "webhooks",
+		ServiceName:   "app-svc",
+		ServiceUser:   "app-user",
+		ContainerPort: 8443,
+		ServerPort:    443,
+		WatchedObjects: []WatchedObjectData{
+			{Flag: "state-configmap", Resource: "configmaps", Namespace: "other", Name: "state"},
+			{Flag: "config-secret", Resource: "secrets", Namespace: "webhooks", Name: "config"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// documents by their kind and name
+	documents := make(map[string]map[string]interface{})
+	for _, document := range strings.Split(rendered, "\n---\n") {
+		var object map[string]interface{}
+		if err = yaml.Unmarshal([]byte(document), &object); err != nil {
+			t.Fatalf("Invalid document %s: %v", document, err)
+		}
+		name := object["metadata"].(map[string]interface{})["name"].(string)
+		documents[object["kind"].(string)+"/"+name] = object
+	}
+
+	if !strings.Contains(rendered, "- \"--state-configmap\"\n            - \"other/state\"") {
+		t.Error("Watched object is not passed to the server")
+	}
+	podSpec := documents["Deployment/app"]["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"]
+	if podSpec.(map[string]interface{})["serviceAccountName"] != "app-user" {
+		t.Errorf("Pod must run with the service user, got %v", podSpec)
+	}
+
+	tests := []struct {
+		role      string
+		namespace string
+		resource  string
+		name      string
+	}{
+		{role: "app-state-configmap", namespace: "other", resource: "configmaps", name: "state"},
+		{role: "app-config-secret", namespace: "webhooks", resource: "secrets", name: "config"},
+	}
+	for _, test := range tests {
+		role, ok := documents["Role/"+test.role]
+		if !ok {
+			t.Fatalf("Role %s is not rendered", test.role)
+		}
+		rule := role["rules"].([]interface{})[0].(map[string]interface{})
+		if role["metadata"].(map[string]interface{})["namespace"] != test.namespace ||
+			rule["resources"].([]interface{})[0] != test.resource ||
+			rule["resourceNames"].([]interface{})[0] != test.name ||
+			len(rule["verbs"].([]interface{})) != 3 {
+			t.Errorf("Unexpected role %v", role)
+		}
+		binding, ok := documents["RoleBinding/"+test.role]
+		if !ok {
+			t.Fatalf("RoleBinding %s is not rendered", test.role)
+		}
+		subject := binding["subjects"].([]interface{})[0].(map[string]interface{})
+		if subject["name"] != "app-user" || subject["namespace"] != "webhooks" {
+			t.Errorf("Unexpected subject %v", subject)
+		}
+	}
+}
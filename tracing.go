@@ -0,0 +1,189 @@
+package webhook_core
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	admissionApi "k8s.io/api/admission/v1"
+)
+
+// TracerName name of the tracer that create spans of this library
+const TracerName = "github.com/devops-simba/webhook_core"
+
+const (
+	// TraceExporterNone tracing is disabled
+	TraceExporterNone = "none"
+	// TraceExporterStdout spans are written to standard output
+	TraceExporterStdout = "stdout"
+	// TraceExporterOTLP spans are sent to an OTLP collector over HTTP
+	TraceExporterOTLP = "otlp"
+)
+
+// TracingOptions options that is used to export traces of the admission requests
+type TracingOptions struct {
+	// Exporter exporter of the spans, one of none, stdout or otlp
+	Exporter string
+	// Endpoint address(host:port) of the OTLP collector, empty means default of the exporter
+	Endpoint string
+	// Insecure should we send the spans to the OTLP collector without TLS
+	Insecure bool
+	// SampleRatio ratio of the traces that are sampled when the caller did not decide about sampling
+	SampleRatio float64
+	// ServiceName name of the service in the exported spans
+	ServiceName string
+}
+
+// BindToFlags bind these options to command line flags
+func (this *TracingOptions) BindToFlags(flagset *flag.FlagSet) {
+	flagset.StringVar(&this.Exporter, "trace-exporter", TraceExporterNone,
+		"Exporter of the traces of the admission requests, one of none, stdout or otlp")
+	flagset.StringVar(&this.Endpoint, "trace-endpoint", "",
+		"Address(host:port) of the OTLP collector, default is the default of the exporter")
+	flagset.BoolVar(&this.Insecure, "trace-insecure", false, "Send traces to the OTLP collector without TLS")
+	flagset.Float64Var(&this.SampleRatio, "trace-sample-ratio", 1,
+		"Ratio of the traces that are sampled, when the API server did not decide about sampling")
+}
+
+// SetupTracing register a global tracer provider according to the options and propagate trace context in W3C
+// format. Returned function flush and stop the exporter.
+func SetupTracing(options TracingOptions) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(options.Exporter) {
+	case "", TraceExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TraceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TraceExporterOTLP:
+		var exporterOptions []otlptracehttp.Option
+		if options.Endpoint != "" {
+			exporterOptions = append(exporterOptions, otlptracehttp.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			exporterOptions = append(exporterOptions, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), exporterOptions...)
+	default:
+		return nil, fmt.Errorf("Invalid trace exporter(%s), valid exporters are none, stdout and otlp", options.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(options.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// getTracer get tracer of this library from the global tracer provider
+func getTracer() trace.Tracer { return otel.Tracer(TracerName) }
+
+// startRequestSpan start span of an admission request, trace context of the API server is used if present in the
+// headers
+func startRequestSpan(r *http.Request, webhook AdmissionWebhook) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := getTracer().Start(ctx, "admission "+webhook.Name(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("webhook.name", webhook.Name()),
+			attribute.String("webhook.type", string(webhook.Type())),
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPTargetKey.String(r.URL.Path),
+		))
+	return r.WithContext(ctx), span
+}
+
+// setRequestSpanAttributes add identity of an admission request to a span
+func setRequestSpanAttributes(span trace.Span, ar *admissionApi.AdmissionReview) {
+	if ar == nil || ar.Request == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.String("admission.uid", string(ar.Request.UID)),
+		attribute.String("admission.operation", string(ar.Request.Operation)),
+		attribute.String("admission.kind", formatRequestKind(ar.Request.Kind)),
+		attribute.String("admission.namespace", ar.Request.Namespace),
+		attribute.String("admission.name", ar.Request.Name),
+		attribute.Bool("admission.dry_run", ar.Request.DryRun != nil && *ar.Request.DryRun),
+	)
+}
+
+// setResponseSpanAttributes add decision of an admission response to a span
+func setResponseSpanAttributes(span trace.Span, response *admissionApi.AdmissionResponse) {
+	if response == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.Bool("admission.allowed", response.Allowed),
+		attribute.Bool("admission.patched", len(response.Patch) != 0),
+	)
+	if !response.Allowed {
+		span.SetAttributes(attribute.String("admission.reason", getResponseMessage(response)))
+	}
+}
+
+// recordSpanError mark a span as failed
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// tracingRoundTripper create a client span for each request and propagate trace context to the server
+type tracingRoundTripper struct {
+	next http.RoundTripper
+}
+
+// NewTracingRoundTripper wrap a `http.RoundTripper` so each request create a span that is a child of the span in the
+// request context, this is used for the clients of `GetClientset` and other kubernetes clients
+func NewTracingRoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &tracingRoundTripper{next: next}
+}
+
+func (this *tracingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, span := getTracer().Start(request.Context(), request.Method+" "+request.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(request.Method),
+			semconv.HTTPURLKey.String(request.URL.String()),
+		))
+	defer span.End()
+
+	request = request.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+	response, err := this.next.RoundTrip(request)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(response.StatusCode))
+	if response.StatusCode >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(response.StatusCode))
+	}
+	return response, nil
+}
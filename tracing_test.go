@@ -0,0 +1,158 @@
+package webhook_core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTraceID    = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentSpan = "00f067aa0ba902b7"
+)
+
+// restoreTracing restore the global tracer provider and propagator when the test finished
+func restoreTracing(t *testing.T) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+}
+
+// recordSpans register a tracer provider that keep ended spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	restoreTracing(t)
+	if _, err := SetupTracing(TracingOptions{Exporter: TraceExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestSetupTracingExporters(t *testing.T) {
+	tests := []struct {
+		options  TracingOptions
+		provider bool
+		invalid  bool
+	}{
+		{options: TracingOptions{}},
+		{options: TracingOptions{Exporter: TraceExporterNone}},
+		{options: TracingOptions{Exporter: "STDOUT", SampleRatio: 1}, provider: true},
+		{options: TracingOptions{Exporter: TraceExporterOTLP, Endpoint: "127.0.0.1:1", Insecure: true}, provider: true},
+		{options: TracingOptions{Exporter: "zipkin"}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.options.Exporter, func(t *testing.T) {
+			restoreTracing(t)
+			otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+			shutdown, err := SetupTracing(test.options)
+			if test.invalid {
+				if err == nil {
+					t.Error("Expected an error for an invalid exporter")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetupTracing failed: %v", err)
+			}
+			if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok != test.provider {
+				t.Errorf("Expected a registered tracer provider to be %v, got %T", test.provider,
+					otel.GetTracerProvider())
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err = shutdown(ctx); err != nil {
+				t.Errorf("Shutdown failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestStartRequestSpanContinueTraceOfAPIServer(t *testing.T) {
+	recorder := recordSpans(t)
+	webhook := newTestWebhook("traced", ValidatingAdmissionWebhook, nil)
+
+	r := httptest.NewRequest(http.MethodPost, "/traced", nil)
+	r.Header.Set("traceparent", "00-"+testTraceID+"-"+testParentSpan+"-01")
+	r, span := startRequestSpan(r, webhook)
+	if trace.SpanFromContext(r.Context()) != span {
+		t.Error("Span must be in the context of the returned request")
+	}
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected one span, got %d", len(spans))
+	}
+	if traceID := spans[0].SpanContext().TraceID().String(); traceID != testTraceID {
+		t.Errorf("Expected span in trace %s, got %s", testTraceID, traceID)
+	}
+	if parent := spans[0].Parent(); !parent.IsRemote() || parent.SpanID().String() != testParentSpan {
+		t.Errorf("Expected span of the API server as parent, got %v", parent.SpanID())
+	}
+	if spans[0].SpanKind() != trace.SpanKindServer || spanAttribute(spans[0], "webhook.name").AsString() != "traced" {
+		t.Errorf("Unexpected span %s(%v)", spans[0].Name(), spans[0].Attributes())
+	}
+}
+
+func TestTracingRoundTripperPropagateTraceContext(t *testing.T) {
+	recorder := recordSpans(t)
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := (&http.Client{Transport: NewTracingRoundTripper(nil)}).Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected client and parent spans, got %d", len(spans))
+	}
+	client := spans[0]
+	if client.Parent().SpanID() != parent.SpanContext().SpanID() || client.SpanKind() != trace.SpanKindClient {
+		t.Errorf("Expected a client span as child of the request span, got %s", client.Name())
+	}
+	expected := "00-" + client.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+	if header := <-received; header != expected {
+		t.Errorf("Expected traceparent %s, got %s", expected, header)
+	}
+	if spanAttribute(client, "http.status_code").AsInt64() != http.StatusNotFound {
+		t.Errorf("Status code is not recorded: %v", client.Attributes())
+	}
+	if request.Header.Get("traceparent") != "" {
+		t.Error("Original request must not change")
+	}
+}
@@ -23,6 +23,12 @@ type WebhookConfiguration struct {
 	Name         string
 	Desc         string
 	DefaultValue *string
+	// Type type of the value of this configuration, empty means `ConfigString`
+	Type ConfigType
+	// Required is it an error if this configuration has no value
+	Required bool
+	// Sensitive value of this configuration is a secret(e.g. a token) and it must not be dumped
+	Sensitive bool
 }
 
 func CreateConfig(name, defaultValue, desc string) WebhookConfiguration {
@@ -33,6 +39,23 @@ func CreateConfig(name, defaultValue, desc string) WebhookConfiguration {
 	}
 }
 
+// CreateTypedConfig create a configuration that its value must be a valid `configType`
+func CreateTypedConfig(name string, configType ConfigType, defaultValue, desc string) WebhookConfiguration {
+	config := CreateConfig(name, defaultValue, desc)
+	config.Type = configType
+	return config
+}
+
+// CreateRequiredConfig create a configuration without default value that must be provided
+func CreateRequiredConfig(name string, configType ConfigType, desc string) WebhookConfiguration {
+	return WebhookConfiguration{
+		Name:     name,
+		Desc:     desc,
+		Type:     configType,
+		Required: true,
+	}
+}
+
 // Webhook This interface represent a webhook
 type AdmissionWebhook interface {
 	// Name name of this webhook
@@ -51,9 +74,30 @@ type AdmissionWebhook interface {
 	SideEffects() admissionRegistration.SideEffectClass
 	// Initialize added an opportunity to initialize before actual running
 	Initialize()
-	// Handler that will be used to process HTTP requests that sent to this plugin
+	// Handler that will be used to process HTTP requests that sent to this plugin, context of the request will be
+	// cancelled when the client disconnect or when deadline of the request(slightly shorter than timeout) reached
 	HandleAdmission(
 		request *http.Request,
 		ar *admissionApi.AdmissionReview,
 	) (*admissionApi.AdmissionResponse, error)
 }
+
+// ConfigurableWebhook webhooks that implement this interface get their configuration bound to a struct before their
+// `Initialize` get called, see `BindConfiguration` for supported tags
+type ConfigurableWebhook interface {
+	// ConfigurationTarget pointer to a struct that its tagged fields should be filled with configuration values
+	ConfigurationTarget() interface{}
+}
+
+// WebhookContainer webhooks that contain other webhooks, like `CompositeWebhook`
+type WebhookContainer interface {
+	// Webhooks get contained webhooks
+	Webhooks() []AdmissionWebhook
+}
+
+// ClientAwareWebhook webhooks that implement this interface will receive the client provider of the command
+// before their `Initialize` get called
+type ClientAwareWebhook interface {
+	// SetClientProvider set provider that this webhook should use to communicate with kubernetes
+	SetClientProvider(provider ClientProvider)
+}
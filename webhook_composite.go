@@ -0,0 +1,403 @@
+package webhook_core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/devops-simba/helpers"
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionApi "k8s.io/api/admission/v1"
+	admissionRegistration "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// MaxTimeoutInSeconds maximum timeout that kubernetes accept for a webhook
+	MaxTimeoutInSeconds = 30
+)
+
+var (
+	ErrNoWebhooks         = errors.New("Composite webhook must contain at least one webhook")
+	ErrMixedWebhookTypes  = errors.New("All webhooks of a composite webhook must have the same type")
+	ErrUnsupportedPatch   = errors.New("Only JSONPatch responses may be combined")
+	sideEffectClassWeight = map[admissionRegistration.SideEffectClass]int{
+		admissionRegistration.SideEffectClassNone:         0,
+		admissionRegistration.SideEffectClassNoneOnDryRun: 1,
+		admissionRegistration.SideEffectClassSome:         2,
+		admissionRegistration.SideEffectClassUnknown:      3,
+	}
+)
+
+// CompositeWebhook serve several webhooks of the same type from a single endpoint. Validating webhooks are all
+// evaluated and their denials are aggregated, mutating webhooks are evaluated in order, each of them see the object
+// that is patched by previous ones and in the end a single merged patch is returned. Each webhook only see requests
+// that match its own rules. Webhooks that are disabled in the `WebhookRegistry` by their own name are skipped and
+// enforcement mode of each webhook is applied to its own response.
+type CompositeWebhook struct {
+	name        string
+	webhookType AdmissionWebhookType
+	webhooks    []AdmissionWebhook
+	registry    *WebhookRegistry
+}
+
+// NewCompositeWebhook create a webhook with `name` that serve all of `webhooks`
+func NewCompositeWebhook(name string, webhooks ...AdmissionWebhook) (*CompositeWebhook, error) {
+	if len(webhooks) == 0 {
+		return nil, ErrNoWebhooks
+	}
+
+	webhookType := webhooks[0].Type()
+	for _, webhook := range webhooks[1:] {
+		if webhook.Type() != webhookType {
+			return nil, ErrMixedWebhookTypes
+		}
+	}
+
+	return &CompositeWebhook{
+		name:        name,
+		webhookType: webhookType,
+		webhooks:    webhooks,
+	}, nil
+}
+
+// Webhooks get webhooks that are served by this composite webhook
+func (this *CompositeWebhook) Webhooks() []AdmissionWebhook { return this.webhooks }
+
+func (this *CompositeWebhook) Name() string               { return this.name }
+func (this *CompositeWebhook) Type() AdmissionWebhookType { return this.webhookType }
+func (this *CompositeWebhook) Rules() []admissionRegistration.RuleWithOperations {
+	var result []admissionRegistration.RuleWithOperations
+	for _, webhook := range this.webhooks {
+		result = append(result, webhook.Rules()...)
+	}
+	return result
+}
+func (this *CompositeWebhook) Configurations() []WebhookConfiguration {
+	var result []WebhookConfiguration
+	for _, webhook := range this.webhooks {
+		result = append(result, webhook.Configurations()...)
+	}
+	return result
+}
+func (this *CompositeWebhook) TimeoutInSeconds() int {
+	result := 0
+	for _, webhook := range this.webhooks {
+		timeout := webhook.TimeoutInSeconds()
+		if timeout <= 0 {
+			timeout = DefaultTimeoutInSeconds
+		}
+		if this.webhookType == MutatingAdmissionWebhook {
+			// mutating webhooks are executed one after another
+			result += timeout
+		} else if timeout > result {
+			result = timeout
+		}
+	}
+	if result > MaxTimeoutInSeconds {
+		result = MaxTimeoutInSeconds
+	}
+	return result
+}
+func (this *CompositeWebhook) SupportedAdmissionVersions() []string {
+	result := this.webhooks[0].SupportedAdmissionVersions()
+	for _, webhook := range this.webhooks[1:] {
+		versions := webhook.SupportedAdmissionVersions()
+		var common []string
+		for _, version := range result {
+			if helpers.ContainsString(versions, version) {
+				common = append(common, version)
+			}
+		}
+		result = common
+	}
+	return result
+}
+func (this *CompositeWebhook) SideEffects() admissionRegistration.SideEffectClass {
+	result := admissionRegistration.SideEffectClassNone
+	for _, webhook := range this.webhooks {
+		sideEffects := webhook.SideEffects()
+		if sideEffects == "" {
+			sideEffects = admissionRegistration.SideEffectClassNone
+		}
+		if sideEffectClassWeight[sideEffects] > sideEffectClassWeight[result] {
+			result = sideEffects
+		}
+	}
+	return result
+}
+func (this *CompositeWebhook) SetClientProvider(provider ClientProvider) {
+	for _, webhook := range this.webhooks {
+		if clientAware, ok := webhook.(ClientAwareWebhook); ok {
+			clientAware.SetClientProvider(provider)
+		}
+	}
+}
+func (this *CompositeWebhook) SetWebhookRegistry(registry *WebhookRegistry) {
+	this.registry = registry
+	for _, webhook := range this.webhooks {
+		if registryAware, ok := webhook.(RegistryAwareWebhook); ok {
+			registryAware.SetWebhookRegistry(registry)
+		}
+	}
+}
+func (this *CompositeWebhook) Initialize() {
+	for _, webhook := range this.webhooks {
+		webhook.Initialize()
+	}
+}
+func (this *CompositeWebhook) HandleAdmission(
+	request *http.Request,
+	ar *admissionApi.AdmissionReview) (*admissionApi.AdmissionResponse, error) {
+	return this.HandleAdmissionRequest(NewAdmissionRequest(request, ar, this, nil))
+}
+func (this *CompositeWebhook) HandleAdmissionRequest(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+	if this.webhookType == MutatingAdmissionWebhook {
+		return this.mutate(request)
+	}
+	return this.validate(request)
+}
+
+// getChildren get webhooks of this composite that are enabled and match `request`
+func (this *CompositeWebhook) getChildren(request *AdmissionRequest) []AdmissionWebhook {
+	var result []AdmissionWebhook
+	for _, webhook := range this.webhooks {
+		if this.registry != nil && !this.registry.IsEnabled(webhook) {
+			continue
+		}
+		if IsRequestMatchRules(request.Request(), webhook.Rules()) {
+			result = append(result, webhook)
+		}
+	}
+	return result
+}
+
+// invokeChild invoke one of the webhooks of this composite with a copy of `request` and `review`, it return the
+// response even if webhook denied the request with a `PolicyError`
+func (this *CompositeWebhook) invokeChild(
+	webhook AdmissionWebhook,
+	request *AdmissionRequest,
+	review *admissionApi.AdmissionReview) (*admissionApi.AdmissionResponse, error) {
+	httpRequest := request.HTTPRequest
+	if httpRequest != nil {
+		// children may run concurrently, so they must not share headers of the request
+		httpRequest = httpRequest.Clone(httpRequest.Context())
+	}
+	response, err := invokeWebhook(NewAdmissionRequest(httpRequest, review, webhook, request.Clients))
+	if err != nil {
+		var policyErr *PolicyError
+		if !errors.As(err, &policyErr) {
+			return nil, fmt.Errorf("%s: %w", webhook.Name(), err)
+		}
+		response = policyErr.Response()
+	}
+	if response == nil {
+		response = &admissionApi.AdmissionResponse{Allowed: true}
+	}
+	if this.registry != nil {
+		// each child may have its own enforcement mode, e.g. a new policy of the composite may be in warn mode
+		response = this.registry.GetEnforcementMode(webhook).Apply(webhook, response)
+	}
+	return response, nil
+}
+
+// mergeChildResponse copy warnings and audit annotations of the response of a child into `target`
+func mergeChildResponse(target *ResponseBuilder, webhook AdmissionWebhook, response *admissionApi.AdmissionResponse) {
+	target.WithWarnings(response.Warnings...)
+	for key, value := range response.AuditAnnotations {
+		target.WithAuditAnnotation(webhook.Name()+"."+key, value)
+	}
+}
+
+func (this *CompositeWebhook) validate(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+	webhooks := this.getChildren(request)
+	responses := make([]*admissionApi.AdmissionResponse, len(webhooks))
+	errs := make([]error, len(webhooks))
+	wg := sync.WaitGroup{}
+	wg.Add(len(webhooks))
+	for i, webhook := range webhooks {
+		go func(i int, webhook AdmissionWebhook) {
+			defer wg.Done()
+			responses[i], errs[i] = this.invokeChild(webhook, request, request.Review.DeepCopy())
+		}(i, webhook)
+	}
+	wg.Wait()
+
+	errBuilder := helpers.AggregateErrorBuilder{}
+	for _, err := range errs {
+		errBuilder.AddError(err)
+	}
+	if err := errBuilder.GetError(); err != nil {
+		return nil, err
+	}
+
+	var messages []string
+	var causes []metav1.StatusCause
+	var code int32
+	for i, response := range responses {
+		if response.Allowed {
+			continue
+		}
+
+		message := "Request denied"
+		if response.Result != nil {
+			if response.Result.Message != "" {
+				message = response.Result.Message
+			}
+			if response.Result.Details != nil {
+				causes = append(causes, response.Result.Details.Causes...)
+			}
+			if code == 0 {
+				code = response.Result.Code
+			} else if code != response.Result.Code {
+				code = http.StatusForbidden
+			}
+		}
+		messages = append(messages, fmt.Sprintf("[%s] %s", webhooks[i].Name(), message))
+	}
+
+	var builder *ResponseBuilder
+	if len(messages) == 0 {
+		builder = AllowResponse()
+	} else {
+		builder = DenyResponse(strings.Join(messages, "; "))
+		if code != 0 {
+			builder.WithCode(code)
+		}
+		for _, cause := range causes {
+			builder.WithCause(cause.Type, cause.Field, cause.Message)
+		}
+	}
+	for i, response := range responses {
+		mergeChildResponse(builder, webhooks[i], response)
+	}
+	return builder.Response(), nil
+}
+
+func (this *CompositeWebhook) mutate(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+	builder := AllowResponse()
+	object := request.Request().Object.Raw
+	var patches []json.RawMessage
+	for _, webhook := range this.getChildren(request) {
+		// each webhook must see the object that patched by previous webhooks
+		review := request.Review.DeepCopy()
+		if review.Request != nil {
+			review.Request.Object.Raw = object
+			review.Request.Object.Object = nil
+		}
+
+		response, err := this.invokeChild(webhook, request, review)
+		if err != nil {
+			return nil, err
+		}
+		mergeChildResponse(builder, webhook, response)
+		if !response.Allowed {
+			denial := builder.Response()
+			denial.Allowed = false
+			denial.Result = response.Result
+			return denial, nil
+		}
+		if len(response.Patch) == 0 {
+			continue
+		}
+		if len(object) == 0 {
+			// there is nothing to patch, e.g. in DELETE operations
+			request.Logger().V(5).Info("Ignoring patch of a request without object", "child", webhook.Name())
+			continue
+		}
+		if response.PatchType != nil && *response.PatchType != admissionApi.PatchTypeJSONPatch {
+			return nil, fmt.Errorf("%s: %w", webhook.Name(), ErrUnsupportedPatch)
+		}
+
+		patch, err := jsonpatch.DecodePatch(response.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid patch: %w", webhook.Name(), err)
+		}
+		object, err = patch.Apply(object)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to apply patch: %w", webhook.Name(), err)
+		}
+
+		var operations []json.RawMessage
+		if err = json.Unmarshal(response.Patch, &operations); err != nil {
+			return nil, fmt.Errorf("%s: invalid patch: %w", webhook.Name(), err)
+		}
+		// JSONPatch operations are applied in order, so concatenation of patches is equal to applying them one by one
+		patches = append(patches, operations...)
+	}
+
+	response := builder.Response()
+	if len(patches) != 0 {
+		patchBytes, err := json.Marshal(patches)
+		if err != nil {
+			return nil, err
+		}
+		response.Patch = patchBytes
+		response.PatchType = &jsonPatch
+	}
+	return response, nil
+}
+
+func matchRuleValue(values []string, value string) bool {
+	return helpers.ContainsString(values, "*") || helpers.ContainsString(values, value)
+}
+func matchRuleResource(resources []string, resource, subResource string) bool {
+	for _, pattern := range resources {
+		parts := strings.SplitN(pattern, "/", 2)
+		if parts[0] != "*" && parts[0] != resource {
+			continue
+		}
+		if len(parts) == 1 {
+			// patterns without subresource never match subresources
+			if subResource == "" {
+				return true
+			}
+			continue
+		}
+		if parts[1] == "*" || parts[1] == subResource {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRequestMatchRules check if an admission request match any of `rules`, this is the same check that API server
+// perform to decide whether a webhook should be called
+func IsRequestMatchRules(request *admissionApi.AdmissionRequest, rules []admissionRegistration.RuleWithOperations) bool {
+	for _, rule := range rules {
+		operationMatched := false
+		for _, operation := range rule.Operations {
+			if operation == admissionRegistration.OperationAll || string(operation) == string(request.Operation) {
+				operationMatched = true
+				break
+			}
+		}
+		if !operationMatched ||
+			!matchRuleValue(rule.APIGroups, request.Resource.Group) ||
+			!matchRuleValue(rule.APIVersions, request.Resource.Version) ||
+			!matchRuleResource(rule.Resources, request.Resource.Resource, request.SubResource) {
+			continue
+		}
+
+		if rule.Scope != nil {
+			// namespaces have their own name as namespace, but they are cluster scoped
+			clusterScoped := request.Namespace == "" ||
+				(request.Resource.Group == "" && request.Resource.Resource == "namespaces")
+			switch *rule.Scope {
+			case admissionRegistration.ClusterScope:
+				if !clusterScoped {
+					continue
+				}
+			case admissionRegistration.NamespacedScope:
+				if clusterScoped {
+					continue
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
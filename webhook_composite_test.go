@@ -0,0 +1,306 @@
+package webhook_core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionApi "k8s.io/api/admission/v1"
+	admissionRegistration "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const testPod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"test","namespace":"default"}}`
+
+// newCompositeRequest create a request for a Pod that is served by `webhook`
+func newCompositeRequest(webhook AdmissionWebhook) *AdmissionRequest {
+	review := &admissionApi.AdmissionReview{
+		Request: &admissionApi.AdmissionRequest{
+			UID:       "test-uid",
+			Operation: admissionApi.Create,
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Namespace: "default",
+			Name:      "test",
+			Object:    runtime.RawExtension{Raw: []byte(testPod)},
+		},
+	}
+	httpRequest := httptest.NewRequest(http.MethodPost, "/", nil)
+	return NewAdmissionRequest(httpRequest, review, webhook, nil)
+}
+
+// addLabelWebhook a mutating webhook that add a label, `expected` labels must be present in the received object
+func addLabelWebhook(t *testing.T, name, label string, expected ...string) *testWebhook {
+	return newTestWebhook(name, MutatingAdmissionWebhook,
+		func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+			var pod struct {
+				Metadata metav1.ObjectMeta `json:"metadata"`
+			}
+			if err := json.Unmarshal(request.Request().Object.Raw, &pod); err != nil {
+				return nil, err
+			}
+			for _, key := range expected {
+				if _, ok := pod.Metadata.Labels[key]; !ok {
+					t.Errorf("%s did not see label %s that added by previous webhooks", name, key)
+				}
+			}
+			var patches []PatchOperation
+			if pod.Metadata.Labels == nil {
+				patches = append(patches, NewAddPatch("/metadata/labels", map[string]string{}))
+			}
+			patches = append(patches, NewAddPatch("/metadata/labels/"+label, "true"))
+			return CreatePatchResponse(patches)
+		})
+}
+
+func TestCompositeWebhookMutateChainPatches(t *testing.T) {
+	composite, err := NewCompositeWebhook("composite",
+		addLabelWebhook(t, "first", "first"),
+		addLabelWebhook(t, "second", "second", "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := composite.HandleAdmissionRequest(newCompositeRequest(composite))
+	if err != nil {
+		t.Fatalf("Mutation failed: %v", err)
+	}
+	if !response.Allowed || response.PatchType == nil || *response.PatchType != admissionApi.PatchTypeJSONPatch {
+		t.Fatalf("Expected an allowed JSONPatch response, got %+v", response)
+	}
+
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	if err != nil {
+		t.Fatalf("Invalid merged patch %s: %v", response.Patch, err)
+	}
+	patched, err := patch.Apply([]byte(testPod))
+	if err != nil {
+		t.Fatalf("Merged patch %s does not apply to the original object: %v", response.Patch, err)
+	}
+	if !strings.Contains(string(patched), `"labels":{"first":"true","second":"true"}`) {
+		t.Errorf("Unexpected patched object: %s", patched)
+	}
+}
+
+func TestCompositeWebhookMutateUnsupportedPatch(t *testing.T) {
+	mergePatch := newTestWebhook("merge", MutatingAdmissionWebhook,
+		func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+			patchType := admissionApi.PatchType("MergePatch")
+			return &admissionApi.AdmissionResponse{
+				Allowed:   true,
+				Patch:     []byte(`{"metadata":{"labels":{"a":"b"}}}`),
+				PatchType: &patchType,
+			}, nil
+		})
+	composite, err := NewCompositeWebhook("composite", addLabelWebhook(t, "first", "first"), mergePatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = composite.HandleAdmissionRequest(newCompositeRequest(composite))
+	if !errors.Is(err, ErrUnsupportedPatch) {
+		t.Errorf("Expected ErrUnsupportedPatch, got %v", err)
+	}
+}
+
+func TestCompositeWebhookMutateDenial(t *testing.T) {
+	lastCalled := false
+	deny := newTestWebhook("deny", MutatingAdmissionWebhook,
+		func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+			return nil, NewPolicyError(DenyResponse("labels are not allowed").WithAuditAnnotation("rule", "no-labels"))
+		})
+	last := newTestWebhook("last", MutatingAdmissionWebhook,
+		func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+			lastCalled = true
+			return AllowResponse().Response(), nil
+		})
+	composite, err := NewCompositeWebhook("composite", addLabelWebhook(t, "first", "first"), deny, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := composite.HandleAdmissionRequest(newCompositeRequest(composite))
+	if err != nil {
+		t.Fatalf("A denial must not be an error: %v", err)
+	}
+	if response.Allowed || response.Result == nil || response.Result.Message != "labels are not allowed" {
+		t.Errorf("Expected denial of the second webhook, got %+v", response)
+	}
+	if len(response.Patch) != 0 {
+		t.Errorf("A denial must not contain a patch, got %s", response.Patch)
+	}
+	if lastCalled {
+		t.Error("Webhooks after a denial must not be called")
+	}
+	if response.AuditAnnotations["deny.rule"] != "no-labels" {
+		t.Errorf("Audit annotations of the child are not merged: %v", response.AuditAnnotations)
+	}
+}
+
+func TestCompositeWebhookValidateAggregate(t *testing.T) {
+	denyWithCause := func(name, field string) *testWebhook {
+		return newTestWebhook(name, ValidatingAdmissionWebhook,
+			func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+				return nil, NewPolicyError(DenyResponse(field+" is invalid").
+					WithCause(metav1.CauseTypeFieldValueInvalid, field, "invalid").
+					WithAuditAnnotation("field", field))
+			})
+	}
+	allow := newTestWebhook("allow", ValidatingAdmissionWebhook,
+		func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+			return AllowResponse().WithWarnings("deprecated field").Response(), nil
+		})
+	composite, err := NewCompositeWebhook("composite",
+		denyWithCause("image", "spec.image"), allow, denyWithCause("labels", "metadata.labels"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := composite.HandleAdmissionRequest(newCompositeRequest(composite))
+	if err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+	if response.Allowed {
+		t.Fatal("Expected a denial")
+	}
+	expectedMessage := "[image] spec.image is invalid; [labels] metadata.labels is invalid"
+	if response.Result.Message != expectedMessage {
+		t.Errorf("Expected message %q, got %q", expectedMessage, response.Result.Message)
+	}
+	if response.Result.Details == nil || len(response.Result.Details.Causes) != 2 ||
+		response.Result.Details.Causes[0].Field != "spec.image" ||
+		response.Result.Details.Causes[1].Field != "metadata.labels" {
+		t.Errorf("Causes are not aggregated: %+v", response.Result.Details)
+	}
+	if len(response.Warnings) != 1 || response.Warnings[0] != "deprecated field" {
+		t.Errorf("Warnings are not merged: %v", response.Warnings)
+	}
+	for key := range response.AuditAnnotations {
+		if strings.Contains(key, "/") {
+			t.Errorf("Audit annotation key %s must not contain `/`", key)
+		}
+	}
+	if response.AuditAnnotations["image.field"] != "spec.image" ||
+		response.AuditAnnotations["labels.field"] != "metadata.labels" {
+		t.Errorf("Audit annotations are not merged: %v", response.AuditAnnotations)
+	}
+}
+
+func TestIsRequestMatchRules(t *testing.T) {
+	namespaced := admissionRegistration.NamespacedScope
+	cluster := admissionRegistration.ClusterScope
+	rule := func(resources []string, scope *admissionRegistration.ScopeType) []admissionRegistration.RuleWithOperations {
+		return []admissionRegistration.RuleWithOperations{{
+			Operations: []admissionRegistration.OperationType{admissionRegistration.Create},
+			Rule: admissionRegistration.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   resources,
+				Scope:       scope,
+			},
+		}}
+	}
+	request := func(resource, subResource, namespace string) *admissionApi.AdmissionRequest {
+		return &admissionApi.AdmissionRequest{
+			Operation:   admissionApi.Create,
+			Resource:    metav1.GroupVersionResource{Version: "v1", Resource: resource},
+			SubResource: subResource,
+			Namespace:   namespace,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		request  *admissionApi.AdmissionRequest
+		rules    []admissionRegistration.RuleWithOperations
+		expected bool
+	}{
+		{"resource", request("pods", "", "default"), rule([]string{"pods"}, nil), true},
+		{"other resource", request("services", "", "default"), rule([]string{"pods"}, nil), false},
+		{"other operation", &admissionApi.AdmissionRequest{Operation: admissionApi.Delete,
+			Resource: metav1.GroupVersionResource{Version: "v1", Resource: "pods"}}, rule([]string{"pods"}, nil), false},
+		{"subresource not matched by resource", request("pods", "status", "default"), rule([]string{"pods"}, nil), false},
+		{"subresource", request("pods", "status", "default"), rule([]string{"pods/status"}, nil), true},
+		{"other subresource", request("pods", "exec", "default"), rule([]string{"pods/status"}, nil), false},
+		{"subresource wildcard", request("pods", "exec", "default"), rule([]string{"pods/*"}, nil), true},
+		{"all resources and subresources", request("pods", "exec", "default"), rule([]string{"*/*"}, nil), true},
+		{"namespaced scope", request("pods", "", "default"), rule([]string{"*"}, &namespaced), true},
+		{"namespaced scope rejects cluster", request("nodes", "", ""), rule([]string{"*"}, &namespaced), false},
+		{"cluster scope", request("nodes", "", ""), rule([]string{"*"}, &cluster), true},
+		{"cluster scope rejects namespaced", request("pods", "", "default"), rule([]string{"*"}, &cluster), false},
+		{"namespaces are cluster scoped", request("namespaces", "", "default"), rule([]string{"*"}, &cluster), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matched := IsRequestMatchRules(test.request, test.rules); matched != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, matched)
+			}
+		})
+	}
+}
+
+func TestCompositeWebhookValidateIsolateChildren(t *testing.T) {
+	touchReview := func(name string) *testWebhook {
+		return newTestWebhook(name, ValidatingAdmissionWebhook,
+			func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+				if request.Request().Name != "test" || request.HTTPRequest.Header.Get("X-Child") != "" {
+					return nil, NewPolicyError(DenyResponse("request is changed by a sibling"))
+				}
+				request.Request().Name = name
+				request.Request().Object.Raw = nil
+				request.HTTPRequest.Header.Set("X-Child", name)
+				return AllowResponse().Response(), nil
+			})
+	}
+	composite, err := NewCompositeWebhook("composite", touchReview("first"), touchReview("second"),
+		touchReview("third"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := newCompositeRequest(composite)
+	response, err := composite.HandleAdmissionRequest(request)
+	if err != nil {
+		t.Fatalf("Validation failed: %v", err)
+	}
+	if !response.Allowed {
+		t.Errorf("Children must not see changes of each other: %s", response.Result.Message)
+	}
+	if request.Request().Name != "test" || string(request.Request().Object.Raw) != testPod {
+		t.Error("Children must not change the original review")
+	}
+}
+
+func TestCompositeWebhookMutateDelete(t *testing.T) {
+	calls := 0
+	patchOnDelete := func(name string) *testWebhook {
+		return newTestWebhook(name, MutatingAdmissionWebhook,
+			func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+				calls++
+				return CreatePatchResponse([]PatchOperation{NewAddPatch("/metadata/labels", map[string]string{})})
+			})
+	}
+	composite, err := NewCompositeWebhook("composite", patchOnDelete("first"), patchOnDelete("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := newCompositeRequest(composite)
+	request.Request().Operation = admissionApi.Delete
+	request.Request().OldObject = request.Request().Object
+	request.Request().Object = runtime.RawExtension{}
+	response, err := composite.HandleAdmissionRequest(request)
+	if err != nil {
+		t.Fatalf("Mutation of a DELETE request failed: %v", err)
+	}
+	if !response.Allowed || len(response.Patch) != 0 || response.PatchType != nil {
+		t.Errorf("Expected an allowed response without patch, got %+v", response)
+	}
+	if calls != 2 {
+		t.Errorf("Expected all children to be called, called %d", calls)
+	}
+}
@@ -0,0 +1,355 @@
+package webhook_core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devops-simba/helpers"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ConfigType type of value of a configuration
+type ConfigType string
+
+const (
+	ConfigString        ConfigType = "string"
+	ConfigInt           ConfigType = "int"
+	ConfigBool          ConfigType = "bool"
+	ConfigDuration      ConfigType = "duration"
+	ConfigList          ConfigType = "list"
+	ConfigRegex         ConfigType = "regex"
+	ConfigLabelSelector ConfigType = "labelSelector"
+)
+
+var (
+	durationType      = reflect.TypeOf(time.Duration(0))
+	stringListType    = reflect.TypeOf([]string(nil))
+	regexType         = reflect.TypeOf((*regexp.Regexp)(nil))
+	labelSelectorType = reflect.TypeOf((*labels.Selector)(nil)).Elem()
+)
+
+// Parse parse a value of this type, result is `string`, `int`, `bool`, `time.Duration`, `[]string`,
+// `*regexp.Regexp` or `labels.Selector`
+func (this ConfigType) Parse(value string) (interface{}, error) {
+	switch this {
+	case ConfigString, "":
+		return value, nil
+	case ConfigInt:
+		return strconv.Atoi(strings.TrimSpace(value))
+	case ConfigBool:
+		return strconv.ParseBool(strings.TrimSpace(value))
+	case ConfigDuration:
+		return time.ParseDuration(strings.TrimSpace(value))
+	case ConfigList:
+		result := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+	case ConfigRegex:
+		return regexp.Compile(value)
+	case ConfigLabelSelector:
+		return labels.Parse(value)
+	default:
+		return nil, fmt.Errorf("Unknown configuration type: %s", this)
+	}
+}
+
+// Parse parse value of this configuration
+func (this WebhookConfiguration) Parse(value string) (interface{}, error) {
+	result, err := this.Type.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for %s(%s): %v", this.Name, this.Type, err)
+	}
+	return result, nil
+}
+
+// Load load value of this configuration from `source`, `found` is false if configuration has no value
+func (this WebhookConfiguration) Load(source ConfigSource) (value interface{}, found bool, err error) {
+	text, ok := source.LookupConfig(this.Name)
+	if !ok {
+		if this.DefaultValue == nil {
+			if this.Required {
+				err = fmt.Errorf("Missing required configuration %s", this.Name)
+			}
+			return
+		}
+		text = *this.DefaultValue
+	}
+
+	found = true
+	value, err = this.Parse(text)
+	return
+}
+
+// ConfigSource a source of configuration values
+type ConfigSource interface {
+	// LookupConfig get value of a configuration by its name
+	LookupConfig(name string) (string, bool)
+}
+
+// EnvConfigSource read configurations from environment variables
+type EnvConfigSource struct{}
+
+func (this EnvConfigSource) LookupConfig(name string) (string, bool) { return os.LookupEnv(name) }
+
+// DirConfigSource read configurations from files of a folder, name of each file is name of a configuration. This is
+// the layout of a mounted ConfigMap or Secret.
+type DirConfigSource string
+
+func (this DirConfigSource) LookupConfig(name string) (string, bool) {
+	if this == "" {
+		return "", false
+	}
+	content, err := ioutil.ReadFile(filepath.Join(string(this), name))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimRight(string(content), "\r\n"), true
+}
+
+// MapConfigSource read configurations from a map, it implement `flag.Value` and accept `NAME=VALUE` items
+type MapConfigSource map[string]string
+
+func (this MapConfigSource) LookupConfig(name string) (string, bool) {
+	value, ok := this[name]
+	return value, ok
+}
+func (this MapConfigSource) String() string {
+	items := make([]string, 0, len(this))
+	for name, value := range this {
+		items = append(items, name+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+func (this *MapConfigSource) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return fmt.Errorf("Invalid configuration(%s), expected `NAME=VALUE`", value)
+	}
+	if *this == nil {
+		*this = make(MapConfigSource)
+	}
+	(*this)[value[:i]] = value[i+1:]
+	return nil
+}
+
+// ConfigSources a list of sources, value of a configuration is read from the first source that has it
+type ConfigSources []ConfigSource
+
+func (this ConfigSources) LookupConfig(name string) (string, bool) {
+	for _, source := range this {
+		if value, ok := source.LookupConfig(name); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// LoadConfigurations load and validate all `configs` from `source`, configurations without value are not in the
+// result
+func LoadConfigurations(configs []WebhookConfiguration, source ConfigSource) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	errBuilder := helpers.AggregateErrorBuilder{}
+	for _, config := range configs {
+		value, found, err := config.Load(source)
+		if err != nil {
+			errBuilder.AddError(err)
+		} else if found {
+			result[config.Name] = value
+		}
+	}
+	if err := errBuilder.GetError(); err != nil {
+		return nil, formatConfigErrors(err)
+	}
+	return result, nil
+}
+
+func formatConfigErrors(err error) error {
+	if errs, ok := err.(helpers.AggregateError); ok {
+		messages := make([]string, len(errs))
+		for i, e := range errs {
+			messages[i] = e.Error()
+		}
+		return fmt.Errorf("Invalid configuration: %s", strings.Join(messages, "; "))
+	}
+	return err
+}
+
+type boundField struct {
+	index  []int
+	config WebhookConfiguration
+}
+
+func getConfigType(fieldType reflect.Type) (ConfigType, bool) {
+	switch {
+	case fieldType == durationType:
+		return ConfigDuration, true
+	case fieldType == stringListType:
+		return ConfigList, true
+	case fieldType == regexType:
+		return ConfigRegex, true
+	case fieldType == labelSelectorType:
+		return ConfigLabelSelector, true
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return ConfigString, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ConfigInt, true
+	case reflect.Bool:
+		return ConfigBool, true
+	}
+	return "", false
+}
+
+func getBoundFields(targetType reflect.Type) ([]boundField, error) {
+	if targetType.Kind() != reflect.Ptr || targetType.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Configuration target must be a pointer to a struct, got %v", targetType)
+	}
+
+	structType := targetType.Elem()
+	var result []boundField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag, ok := field.Tag.Lookup("config")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		config := WebhookConfiguration{Name: parts[0], Desc: field.Tag.Get("desc")}
+		if config.Name == "" {
+			return nil, fmt.Errorf("Missing configuration name in tag of %s.%s", structType.Name(), field.Name)
+		}
+		for _, option := range parts[1:] {
+			if option == "required" {
+				config.Required = true
+			} else if option == "sensitive" {
+				config.Sensitive = true
+			} else {
+				return nil, fmt.Errorf("Invalid option(%s) in tag of %s.%s", option, structType.Name(), field.Name)
+			}
+		}
+		if defaultValue, ok := field.Tag.Lookup("default"); ok {
+			config.DefaultValue = &defaultValue
+		}
+
+		config.Type, ok = getConfigType(field.Type)
+		if !ok {
+			return nil, fmt.Errorf("Type of %s.%s(%v) is not supported for configurations",
+				structType.Name(), field.Name, field.Type)
+		}
+		result = append(result, boundField{index: field.Index, config: config})
+	}
+	return result, nil
+}
+
+// ConfigurationsOf get declaration of configurations from tags of a struct, see `BindConfiguration`
+func ConfigurationsOf(target interface{}) ([]WebhookConfiguration, error) {
+	fields, err := getBoundFields(reflect.TypeOf(target))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]WebhookConfiguration, len(fields))
+	for i, field := range fields {
+		result[i] = field.config
+	}
+	return result, nil
+}
+
+// MustConfigurationsOf same as `ConfigurationsOf` but it panic on error
+func MustConfigurationsOf(target interface{}) []WebhookConfiguration {
+	result, err := ConfigurationsOf(target)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// BindConfiguration load configurations that declared in tags of `target` from `source` and set them to the fields.
+// `target` must be a pointer to a struct, fields are declared by tags like:
+//
+//	Timeout time.Duration `config:"TIMEOUT" default:"5s" desc:"Timeout of the lookups"`
+//	Owners  []string      `config:"OWNERS,required"`
+//	Token   string        `config:"TOKEN,required,sensitive"`
+//
+// Supported field types are string, integers, bool, time.Duration, []string, *regexp.Regexp and labels.Selector.
+// Fields without a value keep their current value, values of `sensitive` configurations are redacted in the dumps.
+func BindConfiguration(target interface{}, source ConfigSource) error {
+	fields, err := getBoundFields(reflect.TypeOf(target))
+	if err != nil {
+		return err
+	}
+
+	// all values are validated before any field is set, so `target` is never left partially bound
+	structType := reflect.TypeOf(target).Elem()
+	values := make([]interface{}, len(fields))
+	errBuilder := helpers.AggregateErrorBuilder{}
+	for i, field := range fields {
+		value, _, err := field.config.Load(source)
+		if err == nil && value != nil && field.config.Type == ConfigInt {
+			fieldType := structType.FieldByIndex(field.index).Type
+			if reflect.Zero(fieldType).OverflowInt(int64(value.(int))) {
+				err = fmt.Errorf("Invalid value for %s: %d is out of range of %v", field.config.Name, value, fieldType)
+			}
+		}
+		if err != nil {
+			errBuilder.AddError(err)
+		}
+		values[i] = value
+	}
+	if err = errBuilder.GetError(); err != nil {
+		return formatConfigErrors(err)
+	}
+
+	targetValue := reflect.ValueOf(target).Elem()
+	for i, field := range fields {
+		if values[i] == nil {
+			continue
+		}
+
+		fieldValue := targetValue.FieldByIndex(field.index)
+		value := reflect.ValueOf(values[i])
+		if field.config.Type == ConfigInt {
+			fieldValue.SetInt(value.Int())
+		} else {
+			fieldValue.Set(value.Convert(fieldValue.Type()))
+		}
+	}
+	return nil
+}
+
+// ConfigureWebhook validate configurations of a webhook and bind them if webhook is a `ConfigurableWebhook`,
+// webhooks that are contained in a `WebhookContainer` are also configured
+func ConfigureWebhook(webhook AdmissionWebhook, source ConfigSource) error {
+	if configurable, ok := webhook.(ConfigurableWebhook); ok {
+		if err := BindConfiguration(configurable.ConfigurationTarget(), source); err != nil {
+			return fmt.Errorf("%s: %w", webhook.Name(), err)
+		}
+	} else if _, err := LoadConfigurations(webhook.Configurations(), source); err != nil {
+		return fmt.Errorf("%s: %w", webhook.Name(), err)
+	}
+
+	if container, ok := webhook.(WebhookContainer); ok {
+		for _, child := range container.Webhooks() {
+			if err := ConfigureWebhook(child, source); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
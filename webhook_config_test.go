@@ -0,0 +1,173 @@
+package webhook_core
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestConfigTypeParse(t *testing.T) {
+	tests := []struct {
+		configType ConfigType
+		value      string
+		expected   interface{}
+		invalid    bool
+	}{
+		{configType: ConfigString, value: " text ", expected: " text "},
+		{configType: "", value: "text", expected: "text"},
+		{configType: ConfigInt, value: " 42 ", expected: 42},
+		{configType: ConfigInt, value: "4.2", invalid: true},
+		{configType: ConfigBool, value: "true", expected: true},
+		{configType: ConfigBool, value: "yes", invalid: true},
+		{configType: ConfigDuration, value: "1m30s", expected: 90 * time.Second},
+		{configType: ConfigDuration, value: "90", invalid: true},
+		{configType: ConfigList, value: " a, b,,c ,", expected: []string{"a", "b", "c"}},
+		{configType: ConfigList, value: "", expected: []string{}},
+		{configType: ConfigRegex, value: "^kube-.*$", expected: regexp.MustCompile("^kube-.*$")},
+		{configType: ConfigRegex, value: "(", invalid: true},
+		{configType: ConfigLabelSelector, value: "team=a,tier!=db", expected: labels.SelectorFromSet(nil)},
+		{configType: ConfigLabelSelector, value: "team in (a", invalid: true},
+		{configType: "float", value: "1", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(string(test.configType)+":"+test.value, func(t *testing.T) {
+			value, err := test.configType.Parse(test.value)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			switch expected := test.expected.(type) {
+			case *regexp.Regexp:
+				if value.(*regexp.Regexp).String() != expected.String() {
+					t.Errorf("Expected %v, got %v", expected, value)
+				}
+			case labels.Selector:
+				selector := value.(labels.Selector)
+				if !selector.Matches(labels.Set{"team": "a", "tier": "web"}) ||
+					selector.Matches(labels.Set{"team": "a", "tier": "db"}) {
+					t.Errorf("Unexpected selector %v", selector)
+				}
+			default:
+				if !reflect.DeepEqual(value, test.expected) {
+					t.Errorf("Expected %#v, got %#v", test.expected, value)
+				}
+			}
+		})
+	}
+}
+
+type testBoundConfig struct {
+	Name      string          `config:"TEST_NAME,required" desc:"Name of the test"`
+	Count     int8            `config:"TEST_COUNT" default:"3"`
+	Enabled   bool            `config:"TEST_ENABLED"`
+	Timeout   time.Duration   `config:"TEST_TIMEOUT" default:"5s"`
+	Owners    []string        `config:"TEST_OWNERS"`
+	Pattern   *regexp.Regexp  `config:"TEST_PATTERN"`
+	Selector  labels.Selector `config:"TEST_SELECTOR"`
+	Token     string          `config:"TEST_TOKEN,sensitive"`
+	Ignored   string          `config:"-"`
+	NotConfig string
+}
+
+func TestConfigurationsOf(t *testing.T) {
+	configs, err := ConfigurationsOf(&testBoundConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, config := range configs {
+		names = append(names, config.Name+":"+string(config.Type))
+	}
+	expected := "TEST_NAME:string TEST_COUNT:int TEST_ENABLED:bool TEST_TIMEOUT:duration TEST_OWNERS:list " +
+		"TEST_PATTERN:regex TEST_SELECTOR:labelSelector TEST_TOKEN:string"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(names, " "))
+	}
+	if !configs[0].Required || configs[0].Desc != "Name of the test" || configs[0].DefaultValue != nil {
+		t.Errorf("Unexpected declaration %+v", configs[0])
+	}
+	if configs[1].Required || configs[1].DefaultValue == nil || *configs[1].DefaultValue != "3" {
+		t.Errorf("Unexpected declaration %+v", configs[1])
+	}
+	if token := configs[len(configs)-1]; !token.Sensitive || configs[0].Sensitive {
+		t.Errorf("Only TEST_TOKEN is sensitive, got %+v", token)
+	}
+
+	invalidTargets := map[string]interface{}{
+		"not a pointer": testBoundConfig{},
+		"missing name": &struct {
+			Value string `config:",required"`
+		}{},
+		"invalid option": &struct {
+			Value string `config:"VALUE,optional"`
+		}{},
+		"unsupported type": &struct {
+			Value float64 `config:"VALUE"`
+		}{},
+	}
+	for name, target := range invalidTargets {
+		if _, err = ConfigurationsOf(target); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestBindConfiguration(t *testing.T) {
+	target := &testBoundConfig{Enabled: true, Ignored: "kept", Owners: []string{"old"}}
+	err := BindConfiguration(target, MapConfigSource{
+		"TEST_NAME":     "test",
+		"TEST_TIMEOUT":  "1m",
+		"TEST_PATTERN":  "^a",
+		"TEST_SELECTOR": "team=a",
+	})
+	if err != nil {
+		t.Fatalf("BindConfiguration failed: %v", err)
+	}
+
+	if target.Name != "test" || target.Count != 3 || target.Timeout != time.Minute {
+		t.Errorf("Values and defaults are not bound: %+v", target)
+	}
+	if target.Pattern == nil || !target.Pattern.MatchString("abc") {
+		t.Errorf("Regex is not bound: %v", target.Pattern)
+	}
+	if target.Selector == nil || !target.Selector.Matches(labels.Set{"team": "a"}) {
+		t.Errorf("Label selector is not bound: %v", target.Selector)
+	}
+	// fields without value keep their current value
+	if !target.Enabled || target.Ignored != "kept" || !reflect.DeepEqual(target.Owners, []string{"old"}) {
+		t.Errorf("Fields without value must not change: %+v", target)
+	}
+
+	if err = BindConfiguration(&testBoundConfig{}, MapConfigSource{}); err == nil ||
+		!strings.Contains(err.Error(), "TEST_NAME") {
+		t.Errorf("Expected an error for missing required configuration, got %v", err)
+	}
+}
+
+func TestBindConfigurationDoNotPartiallyBind(t *testing.T) {
+	tests := map[string]MapConfigSource{
+		"out of range": {"TEST_NAME": "new", "TEST_OWNERS": "a,b", "TEST_COUNT": "300"},
+		"invalid":      {"TEST_NAME": "new", "TEST_OWNERS": "a,b", "TEST_PATTERN": "("},
+	}
+	for name, source := range tests {
+		t.Run(name, func(t *testing.T) {
+			target := &testBoundConfig{Name: "old", Count: 1}
+			if err := BindConfiguration(target, source); err == nil {
+				t.Fatal("Expected an error")
+			}
+			if target.Name != "old" || target.Count != 1 || target.Owners != nil {
+				t.Errorf("Target must not change on error, got %+v", target)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package webhook_core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ReloadableWebhook webhooks that implement this interface receive their new configuration whenever a watched
+// configuration source changes
+type ReloadableWebhook interface {
+	ConfigurableWebhook
+	// ConfigurationChanged called with a fresh copy of `ConfigurationTarget` that new values are bound to it. Webhook
+	// should swap its configuration atomically(e.g. using `LiveConfiguration`) so in-flight requests see a consistent
+	// configuration.
+	ConfigurationChanged(config interface{})
+}
+
+// LiveConfiguration hold configuration of a `ReloadableWebhook` and swap it atomically on changes. Embed it in a
+// webhook and call `Get` once per request, so each request see a consistent configuration.
+type LiveConfiguration struct {
+	value atomic.Value
+}
+
+// NewLiveConfiguration create a live configuration, `target` is a pointer to a struct with configuration tags
+func NewLiveConfiguration(target interface{}) *LiveConfiguration {
+	result := &LiveConfiguration{}
+	result.value.Store(target)
+	return result
+}
+
+// Get get current configuration
+func (this *LiveConfiguration) Get() interface{}                        { return this.value.Load() }
+func (this *LiveConfiguration) ConfigurationTarget() interface{}        { return this.Get() }
+func (this *LiveConfiguration) ConfigurationChanged(config interface{}) { this.value.Store(config) }
+
+// ReloadWebhook bind configuration of a `ReloadableWebhook` from `source` and pass it to the webhook, webhooks that are
+// contained in a `WebhookContainer` are also reloaded. Configuration of the webhook is not changed on error. Fields
+// that have no value in `source` get their default or zero value, so a configuration that is removed from the source
+// does not keep its old value. Fields that are not bound to a configuration keep their current value.
+func ReloadWebhook(webhook AdmissionWebhook, source ConfigSource) error {
+	if reloadable, ok := webhook.(ReloadableWebhook); ok {
+		current := reflect.ValueOf(reloadable.ConfigurationTarget())
+		if current.Kind() != reflect.Ptr || current.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("%s: Configuration target must be a pointer to a struct, got %v",
+				webhook.Name(), current.Type())
+		}
+
+		config := reflect.New(current.Type().Elem())
+		config.Elem().Set(current.Elem())
+		if err := resetBoundFields(config); err != nil {
+			return fmt.Errorf("%s: %w", webhook.Name(), err)
+		}
+		if err := BindConfiguration(config.Interface(), source); err != nil {
+			return fmt.Errorf("%s: %w", webhook.Name(), err)
+		}
+		reloadable.ConfigurationChanged(config.Interface())
+		GetLogger().V(5).Info("Configuration reloaded", "webhook", webhook.Name())
+	}
+
+	if container, ok := webhook.(WebhookContainer); ok {
+		for _, child := range container.Webhooks() {
+			if err := ReloadWebhook(child, source); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resetBoundFields set fields of `config` that are bound to a configuration to their zero value
+func resetBoundFields(config reflect.Value) error {
+	fields, err := getBoundFields(config.Type())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		value := config.Elem().FieldByIndex(field.index)
+		value.Set(reflect.Zero(value.Type()))
+	}
+	return nil
+}
+
+// WatchedConfigSource a configuration source that its values are watched and replaced atomically when they change
+type WatchedConfigSource struct {
+	name      string
+	sensitive bool
+	values    atomic.Value
+	lock      sync.Mutex
+	handlers  []func()
+}
+
+func newWatchedConfigSource(name string) *WatchedConfigSource {
+	result := &WatchedConfigSource{name: name}
+	result.values.Store(MapConfigSource{})
+	return result
+}
+
+// Name name of this source, e.g. `configmap:namespace/name`
+func (this *WatchedConfigSource) Name() string { return this.name }
+
+// Sensitive are values of this source secrets, e.g. values of a Secret
+func (this *WatchedConfigSource) Sensitive() bool { return this.sensitive }
+
+// Values get current snapshot of values of this source
+func (this *WatchedConfigSource) Values() MapConfigSource {
+	return this.values.Load().(MapConfigSource)
+}
+
+func (this *WatchedConfigSource) LookupConfig(name string) (string, bool) {
+	return this.Values().LookupConfig(name)
+}
+
+// OnChange register a handler that will be called after values of this source changed
+func (this *WatchedConfigSource) OnChange(handler func()) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.handlers = append(this.handlers, handler)
+}
+
+func (this *WatchedConfigSource) update(values MapConfigSource) {
+	if values == nil {
+		values = MapConfigSource{}
+	}
+
+	this.lock.Lock()
+	if reflect.DeepEqual(this.Values(), values) {
+		this.lock.Unlock()
+		return
+	}
+	this.values.Store(values)
+	handlers := append([]func(){}, this.handlers...)
+	this.lock.Unlock()
+
+	GetLogger().Info("Configuration source changed", "source", this.name)
+	for _, handler := range handlers {
+		handler()
+	}
+}
+
+func watchNamedObject(informer cache.SharedIndexInformer, description string, update func(obj interface{}),
+	stopCh <-chan struct{}) error {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj interface{}) { update(obj) },
+		DeleteFunc: func(obj interface{}) {
+			GetLogger().Info("Configuration source deleted", "source", description)
+			update(nil)
+		},
+	})
+
+	go informer.Run(stopCh)
+	return waitForInformerSync(informer, "configuration source "+description, stopCh)
+}
+
+func newNamedInformerFactory(clients ClientProvider, namespace, name string) (informers.SharedInformerFactory, error) {
+	clientset, err := clients.GetClientset()
+	if err != nil {
+		return nil, err
+	}
+	return informers.NewSharedInformerFactoryWithOptions(clientset, 10*time.Minute,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		})), nil
+}
+
+// WatchConfigMapSource create a configuration source from data of a ConfigMap, it return after initial values are
+// loaded and watch continue until `stopCh` is closed
+func WatchConfigMapSource(clients ClientProvider, namespace, name string, stopCh <-chan struct{}) (*WatchedConfigSource, error) {
+	factory, err := newNamedInformerFactory(clients, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newWatchedConfigSource("configmap:" + namespace + "/" + name)
+	err = watchNamedObject(factory.Core().V1().ConfigMaps().Informer(), result.name, func(obj interface{}) {
+		if configMap, ok := obj.(*corev1.ConfigMap); ok {
+			result.update(configMap.Data)
+		} else {
+			result.update(nil)
+		}
+	}, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WatchSecretSource create a configuration source from data of a Secret, it return after initial values are loaded
+// and watch continue until `stopCh` is closed
+func WatchSecretSource(clients ClientProvider, namespace, name string, stopCh <-chan struct{}) (*WatchedConfigSource, error) {
+	factory, err := newNamedInformerFactory(clients, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newWatchedConfigSource("secret:" + namespace + "/" + name)
+	result.sensitive = true
+	err = watchNamedObject(factory.Core().V1().Secrets().Informer(), result.name, func(obj interface{}) {
+		values := MapConfigSource{}
+		if secret, ok := obj.(*corev1.Secret); ok {
+			for key, value := range secret.Data {
+				values[key] = string(value)
+			}
+			for key, value := range secret.StringData {
+				values[key] = value
+			}
+		}
+		result.update(values)
+	}, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WatchDirSource create a configuration source from files of a folder(see `DirConfigSource`) and poll it for changes
+// every `interval` until `stopCh` is closed
+func WatchDirSource(dir string, interval time.Duration, stopCh <-chan struct{}) (*WatchedConfigSource, error) {
+	result := newWatchedConfigSource("dir:" + dir)
+	values, err := readConfigDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result.update(values)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				values, err := readConfigDir(dir)
+				if err != nil {
+					GetLogger().Error(err, "Failed to read configuration folder", "dir", dir)
+					continue
+				}
+				result.update(values)
+			}
+		}
+	}()
+	return result, nil
+}
+
+// readConfigDir read all configurations of a folder, hidden entries that kubernetes use for atomic update of the
+// mounted volumes(e.g. `..data`) are ignored
+func readConfigDir(dir string) (MapConfigSource, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := MapConfigSource{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "..") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if value, ok := DirConfigSource(dir).LookupConfig(name); ok {
+			result[name] = value
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+package webhook_core
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type testReloadableConfig struct {
+	Owner   string        `config:"TEST_OWNER"`
+	Timeout time.Duration `config:"TEST_TIMEOUT" default:"5s"`
+	Limit   int           `config:"TEST_LIMIT,required"`
+	// not a configuration, it must survive reloads
+	Cache map[string]string
+}
+
+// reloadableTestWebhook a webhook that keep its configuration in a `LiveConfiguration`
+type reloadableTestWebhook struct {
+	*testWebhook
+	*LiveConfiguration
+}
+
+func newReloadableTestWebhook(config *testReloadableConfig) reloadableTestWebhook {
+	return reloadableTestWebhook{
+		testWebhook:       newTestWebhook("reloadable", ValidatingAdmissionWebhook, nil),
+		LiveConfiguration: NewLiveConfiguration(config),
+	}
+}
+
+func TestReloadWebhookResetRemovedValues(t *testing.T) {
+	cache := map[string]string{"key": "value"}
+	webhook := newReloadableTestWebhook(&testReloadableConfig{Cache: cache})
+
+	if err := ReloadWebhook(webhook, MapConfigSource{
+		"TEST_OWNER": "team-a", "TEST_TIMEOUT": "1m", "TEST_LIMIT": "10"}); err != nil {
+		t.Fatalf("ReloadWebhook failed: %v", err)
+	}
+	config := webhook.Get().(*testReloadableConfig)
+	if config.Owner != "team-a" || config.Timeout != time.Minute || config.Limit != 10 {
+		t.Errorf("Configuration is not reloaded: %+v", config)
+	}
+
+	// owner and timeout are removed from the source
+	if err := ReloadWebhook(webhook, MapConfigSource{"TEST_LIMIT": "20"}); err != nil {
+		t.Fatalf("ReloadWebhook failed: %v", err)
+	}
+	config = webhook.Get().(*testReloadableConfig)
+	if config.Owner != "" || config.Timeout != 5*time.Second || config.Limit != 20 {
+		t.Errorf("Removed values must get their zero or default value: %+v", config)
+	}
+	if config.Cache["key"] != "value" {
+		t.Errorf("Fields that are not configurations must keep their value: %+v", config)
+	}
+
+	if err := ReloadWebhook(webhook, MapConfigSource{"TEST_OWNER": "team-b"}); err == nil {
+		t.Error("Expected an error for missing required configuration")
+	}
+	if current := webhook.Get().(*testReloadableConfig); current != config {
+		t.Errorf("Configuration must not change on error, got %+v", current)
+	}
+}
+
+func TestWatchSecretSource(t *testing.T) {
+	provider := NewFakeClientProvider(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "webhooks", Name: "config"},
+		Data:       map[string][]byte{"TOKEN": []byte("secret")},
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	source, err := WatchSecretSource(provider, "webhooks", "config", stopCh)
+	if err != nil {
+		t.Fatalf("WatchSecretSource failed: %v", err)
+	}
+	if value, ok := source.LookupConfig("TOKEN"); !ok || value != "secret" {
+		t.Errorf("Initial values are not loaded, got %q", value)
+	}
+}
+
+func TestWatchConfigSourcesForbidden(t *testing.T) {
+	setInformerSyncTimeout(t, 200*time.Millisecond)
+	watchers := map[string]func(ClientProvider, string, string, <-chan struct{}) (*WatchedConfigSource, error){
+		"configmaps": WatchConfigMapSource,
+		"secrets":    WatchSecretSource,
+	}
+	for resource, watch := range watchers {
+		t.Run(resource, func(t *testing.T) {
+			provider := NewFakeClientProvider()
+			provider.Clientset.PrependReactor("list", resource,
+				func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.NewForbidden(schema.GroupResource{Resource: resource}, "config", nil)
+				})
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			finished := make(chan error, 1)
+			go func() {
+				_, err := watch(provider, "webhooks", "config", stopCh)
+				finished <- err
+			}()
+			select {
+			case err := <-finished:
+				if err == nil {
+					t.Error("Expected an error when source can not be listed")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Watcher did not return when source can not be listed")
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package webhook_core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// InformerSyncTimeout maximum time that watchers wait for the initial state of their object, e.g. when API server is
+// not reachable or listing the object is forbidden
+var InformerSyncTimeout = 30 * time.Second
+
+// WebhookState runtime state of a webhook
+type WebhookState string
+
+const (
+	// WebhookEnabled webhook handle requests using its default enforcement mode
+	WebhookEnabled WebhookState = "enabled"
+	// WebhookDisabled webhook is not called and all requests are allowed
+	WebhookDisabled WebhookState = "disabled"
+	// WebhookEnforced webhook handle requests in `EnforcementEnforce` mode
+	WebhookEnforced = WebhookState(EnforcementEnforce)
+	// WebhookWarnOnly webhook handle requests in `EnforcementWarn` mode
+	WebhookWarnOnly = WebhookState(EnforcementWarn)
+	// WebhookAuditOnly webhook handle requests in `EnforcementAudit` mode
+	WebhookAuditOnly = WebhookState(EnforcementAudit)
+	// WebhookDryRun webhook handle requests in `EnforcementDryRun` mode
+	WebhookDryRun = WebhookState(EnforcementDryRun)
+)
+
+// ParseWebhookState parse a webhook state, it is either enabled, disabled or an enforcement mode
+func ParseWebhookState(value string) (WebhookState, error) {
+	switch state := WebhookState(strings.ToLower(strings.TrimSpace(value))); state {
+	case WebhookEnabled, WebhookDisabled, WebhookEnforced, WebhookWarnOnly, WebhookAuditOnly, WebhookDryRun:
+		return state, nil
+	default:
+		return "", fmt.Errorf(
+			"Invalid webhook state(%s), valid states are enabled, disabled, enforce, warn, audit and dry-run", value)
+	}
+}
+
+// RegistryAwareWebhook webhooks that implement this interface receive the registry that hold them, e.g. so a
+// `CompositeWebhook` can check state of its children
+type RegistryAwareWebhook interface {
+	// SetWebhookRegistry set registry that hold this webhook
+	SetWebhookRegistry(registry *WebhookRegistry)
+}
+
+// WebhookRegistry hold webhooks of the application and their runtime state. State of the webhooks may be changed
+// at runtime, e.g. from a watched ConfigMap, so a misbehaving webhook can be turned off without a redeploy. Webhooks
+// that are contained in a `WebhookContainer` have their own state, so a single policy of a `CompositeWebhook` can be
+// turned off too.
+type WebhookRegistry struct {
+	lock     sync.RWMutex
+	webhooks []AdmissionWebhook
+	states   map[string]WebhookState
+	modes    EnforcementModes
+}
+
+// NewWebhookRegistry create a registry that contains `webhooks`, all of them are enabled
+func NewWebhookRegistry(webhooks ...AdmissionWebhook) *WebhookRegistry {
+	result := &WebhookRegistry{
+		webhooks: webhooks,
+		states:   make(map[string]WebhookState),
+	}
+	for _, webhook := range webhooks {
+		if registryAware, ok := webhook.(RegistryAwareWebhook); ok {
+			registryAware.SetWebhookRegistry(result)
+		}
+	}
+	return result
+}
+
+// forEachWebhook call `fn` for each of `webhooks` and webhooks that they contain
+func forEachWebhook(webhooks []AdmissionWebhook, fn func(webhook AdmissionWebhook)) {
+	for _, webhook := range webhooks {
+		fn(webhook)
+		if container, ok := webhook.(WebhookContainer); ok {
+			forEachWebhook(container.Webhooks(), fn)
+		}
+	}
+}
+
+// SetEnforcementModes set enforcement mode of webhooks that are in `WebhookEnabled` state
+func (this *WebhookRegistry) SetEnforcementModes(modes EnforcementModes) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.modes = modes
+}
+
+// GetEnforcementMode get current enforcement mode of a webhook
+func (this *WebhookRegistry) GetEnforcementMode(webhook AdmissionWebhook) EnforcementMode {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	state, ok := this.states[webhook.Name()]
+	if !ok || state == WebhookEnabled || state == WebhookDisabled {
+		return this.modes.Get(webhook)
+	}
+	return EnforcementMode(state)
+}
+
+// Webhooks get top level webhooks of this registry
+func (this *WebhookRegistry) Webhooks() []AdmissionWebhook { return this.webhooks }
+
+// FindWebhook find a webhook or a contained webhook by its name
+func (this *WebhookRegistry) FindWebhook(name string) AdmissionWebhook {
+	var result AdmissionWebhook
+	forEachWebhook(this.webhooks, func(webhook AdmissionWebhook) {
+		if result == nil && webhook.Name() == name {
+			result = webhook
+		}
+	})
+	return result
+}
+
+// IsEnabled check if a webhook is not disabled
+func (this *WebhookRegistry) IsEnabled(webhook AdmissionWebhook) bool {
+	return this.GetState(webhook.Name()) != WebhookDisabled
+}
+
+// GetState get current state of a webhook
+func (this *WebhookRegistry) GetState(name string) WebhookState {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if state, ok := this.states[name]; ok {
+		return state
+	}
+	return WebhookEnabled
+}
+
+// SetState change state of a webhook
+func (this *WebhookRegistry) SetState(name string, state WebhookState) error {
+	if this.FindWebhook(name) == nil {
+		return fmt.Errorf("Webhook %s is not registered", name)
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.states[name] != state {
+		GetLogger().Info("Webhook state changed", "webhook", name, "state", state)
+	}
+	this.states[name] = state
+	return nil
+}
+
+// SetStates replace state of all webhooks, webhooks that are not in `states` will be enabled
+func (this *WebhookRegistry) SetStates(states map[string]WebhookState) {
+	newStates := make(map[string]WebhookState)
+	for name, state := range states {
+		if this.FindWebhook(name) == nil {
+			GetLogger().Info("Ignoring state of unknown webhook", "webhook", name)
+			continue
+		}
+		newStates[name] = state
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	forEachWebhook(this.webhooks, func(webhook AdmissionWebhook) {
+		name := webhook.Name()
+		oldState, ok := this.states[name]
+		if !ok {
+			oldState = WebhookEnabled
+		}
+		newState, ok := newStates[name]
+		if !ok {
+			newState = WebhookEnabled
+		}
+		if oldState != newState {
+			GetLogger().Info("Webhook state changed", "webhook", name, "oldState", oldState, "state", newState)
+		}
+	})
+	this.states = newStates
+}
+
+// ApplyConfigMap update state of webhooks from a ConfigMap, each key of the ConfigMap is name of a webhook and its
+// value is state of that webhook. Passing nil enable all webhooks.
+func (this *WebhookRegistry) ApplyConfigMap(configMap *corev1.ConfigMap) {
+	states := make(map[string]WebhookState)
+	if configMap != nil {
+		for name, value := range configMap.Data {
+			state, err := ParseWebhookState(value)
+			if err != nil {
+				GetLogger().Error(err, "Ignoring invalid webhook state", "webhook", name,
+					"configMap", configMap.Namespace+"/"+configMap.Name)
+				continue
+			}
+			states[name] = state
+		}
+	}
+	this.SetStates(states)
+}
+
+// WatchConfigMap watch a ConfigMap and update state of webhooks whenever it changes, it return after initial state
+// of the ConfigMap is loaded and watch continue until `stopCh` is closed
+func (this *WebhookRegistry) WatchConfigMap(clients ClientProvider, namespace, name string, stopCh <-chan struct{}) error {
+	factory, err := newNamedInformerFactory(clients, namespace, name)
+	if err != nil {
+		return err
+	}
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if configMap, ok := obj.(*corev1.ConfigMap); ok {
+				this.ApplyConfigMap(configMap)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if configMap, ok := obj.(*corev1.ConfigMap); ok {
+				this.ApplyConfigMap(configMap)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			GetLogger().Info("Webhook state ConfigMap deleted, enabling all webhooks", "configMap", namespace+"/"+name)
+			this.ApplyConfigMap(nil)
+		},
+	})
+
+	factory.Start(stopCh)
+	return waitForInformerSync(informer, "webhook state ConfigMap "+namespace+"/"+name, stopCh)
+}
+
+// waitForInformerSync wait until `informer` loaded its initial state, it fail if `stopCh` is closed or
+// `InformerSyncTimeout` passed
+func waitForInformerSync(informer cache.SharedIndexInformer, description string, stopCh <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), InformerSyncTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("Failed to load %s in %v, check that API server is reachable and the server is allowed "+
+			"to list and watch it", description, InformerSyncTimeout)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package webhook_core
+
+import (
+	"testing"
+	"time"
+
+	admissionApi "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// setInformerSyncTimeout change `InformerSyncTimeout` for the duration of a test
+func setInformerSyncTimeout(t *testing.T, timeout time.Duration) {
+	old := InformerSyncTimeout
+	InformerSyncTimeout = timeout
+	t.Cleanup(func() { InformerSyncTimeout = old })
+}
+
+func TestWebhookRegistryWatchConfigMap(t *testing.T) {
+	provider := NewFakeClientProvider(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "webhooks", Name: "state"},
+		Data:       map[string]string{"test": "disabled"},
+	})
+	registry := NewWebhookRegistry(newTestWebhook("test", ValidatingAdmissionWebhook, nil))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if err := registry.WatchConfigMap(provider, "webhooks", "state", stopCh); err != nil {
+		t.Fatalf("WatchConfigMap failed: %v", err)
+	}
+	if state := registry.GetState("test"); state != WebhookDisabled {
+		t.Errorf("Expected initial state to be loaded, got %s", state)
+	}
+}
+
+func TestWebhookRegistryWatchConfigMapForbidden(t *testing.T) {
+	setInformerSyncTimeout(t, 200*time.Millisecond)
+	provider := NewFakeClientProvider()
+	provider.Clientset.PrependReactor("list", "configmaps",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "state", nil)
+		})
+	registry := NewWebhookRegistry(newTestWebhook("test", ValidatingAdmissionWebhook, nil))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	finished := make(chan error, 1)
+	go func() { finished <- registry.WatchConfigMap(provider, "webhooks", "state", stopCh) }()
+	select {
+	case err := <-finished:
+		if err == nil {
+			t.Error("Expected an error when ConfigMap can not be listed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchConfigMap did not return when ConfigMap can not be listed")
+	}
+}
+
+func TestWebhookRegistryDisableChildOfComposite(t *testing.T) {
+	var called []string
+	child := func(name string) *testWebhook {
+		return newTestWebhook(name, ValidatingAdmissionWebhook,
+			func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+				called = append(called, name)
+				return nil, NewPolicyError(DenyResponse(name + " denied"))
+			})
+	}
+	composite, err := NewCompositeWebhook("composite", child("first"), child("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewWebhookRegistry(composite)
+
+	registry.ApplyConfigMap(&corev1.ConfigMap{Data: map[string]string{"second": "disabled"}})
+	if state := registry.GetState("second"); state != WebhookDisabled {
+		t.Fatalf("State of a child must be set by its name, got %s", state)
+	}
+	if err = registry.SetState("first", WebhookEnabled); err != nil {
+		t.Errorf("Children must be known to the registry: %v", err)
+	}
+	if err = registry.SetState("unknown", WebhookDisabled); err == nil {
+		t.Error("Expected an error for an unknown webhook")
+	}
+
+	response, err := composite.HandleAdmissionRequest(newCompositeRequest(composite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(called) != 1 || called[0] != "first" {
+		t.Errorf("Disabled child must be skipped, called %v", called)
+	}
+	if response.Allowed || response.Result.Message != "[first] first denied" {
+		t.Errorf("Unexpected response %+v", response.Result)
+	}
+}
@@ -0,0 +1,60 @@
+package webhook_core
+
+import (
+	"net/http"
+
+	admissionApi "k8s.io/api/admission/v1"
+	admissionRegistration "k8s.io/api/admissionregistration/v1"
+)
+
+// testWebhook an `AdmissionWebhook` that delegate handling of requests to a function
+type testWebhook struct {
+	name           string
+	webhookType    AdmissionWebhookType
+	rules          []admissionRegistration.RuleWithOperations
+	timeout        int
+	configurations []WebhookConfiguration
+	handler        func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error)
+}
+
+func newTestWebhook(
+	name string,
+	webhookType AdmissionWebhookType,
+	handler func(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error)) *testWebhook {
+	return &testWebhook{
+		name:        name,
+		webhookType: webhookType,
+		rules: []admissionRegistration.RuleWithOperations{{
+			Operations: []admissionRegistration.OperationType{admissionRegistration.OperationAll},
+			Rule: admissionRegistration.Rule{
+				APIGroups:   []string{"*"},
+				APIVersions: []string{"*"},
+				Resources:   []string{"*"},
+			},
+		}},
+		timeout: DefaultTimeoutInSeconds,
+		handler: handler,
+	}
+}
+
+func (this *testWebhook) Name() string                                      { return this.name }
+func (this *testWebhook) Type() AdmissionWebhookType                        { return this.webhookType }
+func (this *testWebhook) Rules() []admissionRegistration.RuleWithOperations { return this.rules }
+func (this *testWebhook) Configurations() []WebhookConfiguration            { return this.configurations }
+func (this *testWebhook) TimeoutInSeconds() int                             { return this.timeout }
+func (this *testWebhook) SupportedAdmissionVersions() []string              { return SupportedAdmissionVersions }
+func (this *testWebhook) SideEffects() admissionRegistration.SideEffectClass {
+	return admissionRegistration.SideEffectClassNone
+}
+func (this *testWebhook) Initialize() {}
+func (this *testWebhook) HandleAdmission(
+	request *http.Request,
+	ar *admissionApi.AdmissionReview) (*admissionApi.AdmissionResponse, error) {
+	return this.HandleAdmissionRequest(NewAdmissionRequest(request, ar, this, nil))
+}
+func (this *testWebhook) HandleAdmissionRequest(request *AdmissionRequest) (*admissionApi.AdmissionResponse, error) {
+	if this.handler == nil {
+		return AllowResponse().Response(), nil
+	}
+	return this.handler(request)
+}